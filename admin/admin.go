@@ -0,0 +1,203 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"namespace-admission-controller/logging"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Masked replaces the values of secret settings
+const Masked = "xxxxx"
+
+// secretKeys are the parts of setting names whose values are masked
+var secretKeys = []string{"token", "password", "secret"}
+
+// Server is the admin endpoint. Without a token only loopback clients are accepted.
+type Server struct {
+	// Token is the bearer token required from clients, loopback only when empty
+	Token string
+	// Settings returns the effective configuration
+	Settings func() map[string]interface{}
+	// Status returns the state of the running components
+	Status func() map[string]interface{}
+	// Reload reads the configuration again
+	Reload func() error
+}
+
+// Handler returns the admin endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", s.config)
+	mux.HandleFunc("/loglevel", s.logLevel)
+	mux.HandleFunc("/status", s.status)
+	mux.HandleFunc("/reload", s.reload)
+	return s.authorize(mux)
+}
+
+// ListenAndServe serves the admin endpoints on the address
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.Token) > 0 {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				audit(r).Warn("Admin request with invalid token rejected")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if !loopback(r.RemoteAddr) {
+			audit(r).Warn("Admin request from remote address rejected")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func loopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// audit returns a logger for the admin log lines of the request
+func audit(r *http.Request) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"audit":  true,
+		"remote": r.RemoteAddr,
+		"method": r.Method,
+		"path":   r.URL.Path,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logrus.Errorln("Failed to write admin response:", err)
+	}
+}
+
+func (s *Server) config(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, MaskSecrets(s.Settings()))
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := map[string]interface{}{}
+	if s.Status != nil {
+		status = s.Status()
+	}
+	status["logLevel"] = logrus.GetLevel().String()
+	status["logLevelSource"] = logging.LevelSource()
+	writeJSON(w, status)
+}
+
+func (s *Server) logLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		level := r.URL.Query().Get("level")
+		old := logrus.GetLevel().String()
+		if err := logging.SetLevel(level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit(r).WithFields(logrus.Fields{
+			"from": old,
+			"to":   logrus.GetLevel().String(),
+		}).Warn("Log level changed")
+	case http.MethodDelete:
+		old := logrus.GetLevel().String()
+		level := logging.ClearLevel()
+		audit(r).WithFields(logrus.Fields{
+			"from": old,
+			"to":   level.String(),
+		}).Warn("Log level override cleared")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]string{"level": logrus.GetLevel().String(), "source": logging.LevelSource()})
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Reload == nil {
+		http.Error(w, "reload not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		audit(r).Errorln("Configuration reload failed:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(r).Warn("Configuration reloaded")
+	writeJSON(w, map[string]string{"status": "reloaded"})
+}
+
+// MaskSecrets returns a copy of the settings where secrets and URL passwords are masked
+func MaskSecrets(settings map[string]interface{}) map[string]interface{} {
+	masked := map[string]interface{}{}
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			masked[key] = MaskSecrets(v)
+		case string:
+			masked[key] = maskValue(key, v)
+		default:
+			if secretKey(key) {
+				masked[key] = Masked
+			} else {
+				masked[key] = v
+			}
+		}
+	}
+	return masked
+}
+
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func maskValue(key string, value string) string {
+	if secretKey(key) {
+		if len(value) == 0 {
+			return value
+		}
+		return Masked
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), Masked)
+			return u.String()
+		}
+	}
+	return value
+}
@@ -0,0 +1,396 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"namespace-admission-controller/logging"
+)
+
+// Stdout is the output writing the captured reviews to the standard output
+const Stdout = "-"
+
+// bufferSize is the number of reviews waiting to be written, the reviews beyond are dropped
+const bufferSize = 1000
+
+// Options configures the capture of AdmissionReviews with their responses
+type Options struct {
+	// Output is a file or Stdout, nothing is captured when empty
+	Output string
+	// MaxBytes is the size of the file when it is rotated, MaxFiles rotated files are kept
+	MaxBytes int64
+	MaxFiles int
+	// SampleRate is the fraction of reviews captured, from 0 to 1
+	SampleRate float64
+	// Kinds and Users select the captured requests by kind and username, all requests when empty
+	Kinds []string
+	Users []string
+	// RedactPaths are dotted JSON field paths, * matches any field or list item
+	RedactPaths []string
+}
+
+var (
+	capturedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bhadmission_capture_reviews_total",
+		Help: "The total number of captured AdmissionReviews",
+	})
+	captureErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bhadmission_capture_errors_total",
+		Help: "The total number of AdmissionReviews that could not be captured",
+	})
+	captureDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bhadmission_capture_dropped_total",
+		Help: "The total number of AdmissionReviews dropped because the capture buffer was full",
+	})
+	// dropped counts the same reviews as captureDropped for the status of the admin endpoint
+	dropped uint64
+)
+
+// Stats describe the buffer of the capture
+type Stats struct {
+	// Enabled is false when capture_output is empty
+	Enabled bool `json:"enabled"`
+	// Queued is the number of reviews waiting to be written
+	Queued int `json:"queued"`
+	// Dropped is the number of reviews dropped since the start because the buffer was full
+	Dropped uint64 `json:"dropped"`
+}
+
+// Sink writes one AdmissionReview per line, the format read by the replay command. The reviews
+// are written by a goroutine of the sink, requests only wait for a place in its buffer.
+type Sink struct {
+	// output is Options.Output, it does not change during the life of the sink
+	output string
+
+	mutex   sync.Mutex
+	options Options
+	writer  io.Writer
+	// file is nil for Stdout
+	file *os.File
+	size int64
+
+	// recordMutex orders Record and Close, no review is queued once closed is set
+	recordMutex sync.RWMutex
+	closed      bool
+	records     chan *v1beta1.AdmissionReview
+	closing     chan struct{}
+	done        chan struct{}
+}
+
+// NewSink opens the output of the options, a file is appended to, and starts writing the recorded reviews
+func NewSink(options Options) (*Sink, error) {
+	sink := &Sink{
+		output:  options.Output,
+		options: options,
+		writer:  os.Stdout,
+		records: make(chan *v1beta1.AdmissionReview, bufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if options.Output != Stdout {
+		if err := sink.open(); err != nil {
+			return nil, err
+		}
+	}
+	go sink.run()
+	return sink, nil
+}
+
+// run writes the recorded reviews until the sink is closed, then the reviews left in the buffer
+func (sink *Sink) run() {
+	defer close(sink.done)
+	for {
+		select {
+		case review := <-sink.records:
+			sink.capture(review)
+		case <-sink.closing:
+			for {
+				select {
+				case review := <-sink.records:
+					sink.capture(review)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (sink *Sink) open() error {
+	file, err := os.OpenFile(sink.output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file = file
+	sink.writer = file
+	sink.size = info.Size()
+	return nil
+}
+
+// rotate renames the file to file.1, file.1 to file.2 and so on, and drops the files after MaxFiles
+func (sink *Sink) rotate() error {
+	if err := sink.file.Close(); err != nil {
+		return err
+	}
+	name := sink.output
+	if sink.options.MaxFiles <= 0 {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return sink.open()
+	}
+	for i := sink.options.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(name+"."+strconv.Itoa(i), name+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(name, name+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return sink.open()
+}
+
+// setOptions replaces the filters of a sink writing to the same output
+func (sink *Sink) setOptions(options Options) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.options = options
+	sink.options.Output = sink.output
+}
+
+// Selects is true when the review is captured according to the kind, user and sample rate
+func (sink *Sink) Selects(review *v1beta1.AdmissionReview) bool {
+	sink.mutex.Lock()
+	options := sink.options
+	sink.mutex.Unlock()
+	if review.Request == nil {
+		return false
+	}
+	if !matches(options.Kinds, review.Request.Kind.Kind, strings.EqualFold) ||
+		!matches(options.Users, review.Request.UserInfo.Username, func(a, b string) bool { return a == b }) {
+		return false
+	}
+	return options.SampleRate >= 1 || rand.Float64() < options.SampleRate
+}
+
+func matches(values []string, value string, equal func(string, string) bool) bool {
+	found := true
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) == 0 {
+			continue
+		}
+		if equal(v, value) {
+			return true
+		}
+		found = false
+	}
+	return found
+}
+
+// Record queues the review with its response when it is selected. The review must not be modified
+// afterwards, it is redacted and written later. It is dropped when the buffer is full or the sink
+// is closed.
+func (sink *Sink) Record(review *v1beta1.AdmissionReview) {
+	if !sink.record(review) {
+		drop()
+	}
+}
+
+// record queues the review when it is selected, false when the sink is closed
+func (sink *Sink) record(review *v1beta1.AdmissionReview) bool {
+	if !sink.Selects(review) {
+		return true
+	}
+	sink.recordMutex.RLock()
+	defer sink.recordMutex.RUnlock()
+	if sink.closed {
+		return false
+	}
+	select {
+	case sink.records <- review:
+	default:
+		drop()
+	}
+	return true
+}
+
+// drop counts a review that was not captured
+func drop() {
+	captureDropped.Inc()
+	atomic.AddUint64(&dropped, 1)
+}
+
+// capture writes the redacted review
+func (sink *Sink) capture(review *v1beta1.AdmissionReview) {
+	if err := sink.write(review); err != nil {
+		captureErrors.Inc()
+		logging.ForRequest(review.Request).Errorln("Failed to capture review:", err)
+		return
+	}
+	capturedTotal.Inc()
+}
+
+func (sink *Sink) write(review *v1beta1.AdmissionReview) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	// the structure is kept for replaying the redacted reviews
+	body, err := logging.RedactedReview(review, sink.options.RedactPaths, logging.RedactLeaves)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if sink.file != nil && sink.options.MaxBytes > 0 && sink.size > 0 && sink.size+int64(len(line)) > sink.options.MaxBytes {
+		if err := sink.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := sink.writer.Write(line)
+	sink.size += int64(n)
+	return err
+}
+
+// Close writes the reviews recorded before and closes the file of the sink
+func (sink *Sink) Close() error {
+	sink.recordMutex.Lock()
+	if !sink.closed {
+		sink.closed = true
+		close(sink.closing)
+	}
+	sink.recordMutex.Unlock()
+	<-sink.done
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.file == nil {
+		return nil
+	}
+	err := sink.file.Close()
+	sink.file = nil
+	sink.writer = ioutil.Discard
+	return err
+}
+
+var (
+	// configureMutex serializes Configure, Record only loads the current sink
+	configureMutex sync.Mutex
+	current        atomic.Value
+)
+
+// sinkValue keeps the stored type constant for atomic.Value, sink is nil when capture is disabled
+type sinkValue struct {
+	sink *Sink
+}
+
+func init() {
+	current.Store(sinkValue{})
+}
+
+// Configure replaces the capture options. The file stays open when the output does not change.
+func Configure(options Options) error {
+	pending, err := Prepare(options)
+	if err != nil {
+		return err
+	}
+	pending.Apply()
+	return nil
+}
+
+// Pending holds capture options whose output is open but not used yet
+type Pending struct {
+	options Options
+	// sink is nil when the running sink keeps its output or capture is disabled
+	sink *Sink
+}
+
+// Prepare opens the output of the options without changing the running capture, so that a
+// configuration is only applied once every part of it is valid. The options are applied by
+// Apply or dropped by Discard, no other configuration may be applied in between.
+func Prepare(options Options) (*Pending, error) {
+	configureMutex.Lock()
+	defer configureMutex.Unlock()
+	pending := &Pending{options: options}
+	previous := current.Load().(sinkValue).sink
+	if len(options.Output) == 0 || (previous != nil && previous.output == options.Output) {
+		return pending, nil
+	}
+	var err error
+	if pending.sink, err = NewSink(options); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Apply replaces the running capture and closes the output it no longer uses
+func (pending *Pending) Apply() {
+	configureMutex.Lock()
+	defer configureMutex.Unlock()
+	previous := current.Load().(sinkValue).sink
+	if pending.sink == nil && previous != nil && previous.output == pending.options.Output {
+		previous.setOptions(pending.options)
+		return
+	}
+	if pending.sink != nil {
+		logrus.Infoln("Capturing AdmissionReviews to", pending.options.Output)
+	}
+	current.Store(sinkValue{pending.sink})
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			logrus.Warnln("Failed to close the capture file:", err)
+		}
+	}
+}
+
+// Discard closes the output opened by Prepare
+func (pending *Pending) Discard() {
+	if pending.sink == nil {
+		return
+	}
+	if err := pending.sink.Close(); err != nil {
+		logrus.Warnln("Failed to close the capture file:", err)
+	}
+}
+
+// CurrentStats returns the stats of the configured sink, false when capture is disabled
+func CurrentStats() (Stats, bool) {
+	sink := current.Load().(sinkValue).sink
+	if sink == nil {
+		return Stats{Dropped: atomic.LoadUint64(&dropped)}, false
+	}
+	return Stats{Enabled: true, Queued: len(sink.records), Dropped: atomic.LoadUint64(&dropped)}, true
+}
+
+// Record captures the review with the configured sink. A sink is closed after it was replaced
+// by Configure, the review is then recorded by its replacement.
+func Record(review *v1beta1.AdmissionReview) {
+	var closed *Sink
+	for {
+		sink := current.Load().(sinkValue).sink
+		if sink == nil || sink.record(review) {
+			return
+		}
+		if sink == closed {
+			drop()
+			return
+		}
+		closed = sink
+	}
+}
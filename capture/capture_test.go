@@ -0,0 +1,208 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"namespace-admission-controller/logging"
+)
+
+func testReview(kind, username string) *v1beta1.AdmissionReview {
+	return &v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: "admission.k8s.io/v1beta1"},
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "e911857d-c318-11e8-bbad-025000000001",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+			Name:      "test",
+			Operation: v1beta1.Create,
+			UserInfo: authenticationv1.UserInfo{
+				Username: username,
+				Extra:    map[string]authenticationv1.ExtraValue{"scopes.authorization.openshift.io": {"user:full"}},
+			},
+			Object: runtime.RawExtension{Raw: []byte(`{"kind":"` + kind + `","metadata":{"name":"test"}}`)},
+		},
+		Response: &v1beta1.AdmissionResponse{UID: "e911857d-c318-11e8-bbad-025000000001", Allowed: true},
+	}
+}
+
+func TestSelects(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		review   *v1beta1.AdmissionReview
+		selected bool
+	}{
+		{"all", Options{Kinds: []string{""}, SampleRate: 1}, testReview("Namespace", "alice"), true},
+		{"kind", Options{Kinds: []string{"namespace", "User"}, SampleRate: 1}, testReview("Namespace", "alice"), true},
+		{"other kind", Options{Kinds: []string{"User"}, SampleRate: 1}, testReview("Namespace", "alice"), false},
+		{"user", Options{Users: []string{"alice"}, SampleRate: 1}, testReview("Namespace", "alice"), true},
+		{"other user", Options{Users: []string{"bob"}, SampleRate: 1}, testReview("Namespace", "alice"), false},
+		{"not sampled", Options{SampleRate: 0}, testReview("Namespace", "alice"), false},
+		{"without request", Options{SampleRate: 1}, &v1beta1.AdmissionReview{}, false},
+	}
+	for _, test := range tests {
+		sink := &Sink{options: test.options}
+		if sink.Selects(test.review) != test.selected {
+			t.Errorf("%s: expected selected=%v", test.name, test.selected)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "reviews.jsonl")
+	sink, err := NewSink(Options{Output: output, SampleRate: 1, RedactPaths: logging.DefaultRedactPaths})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Record(testReview("Namespace", "alice"))
+	sink.Record(testReview("User", "bob"))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+		// the lines are read back as AdmissionReviews by the replay command
+		review := &v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(scanner.Bytes(), review); err != nil {
+			t.Fatal(err)
+		}
+		if review.Request == nil || review.Response == nil || review.Request.Name != "test" {
+			t.Error("incomplete review:", scanner.Text())
+		}
+		if !strings.Contains(scanner.Text(), `"extra":{"scopes.authorization.openshift.io":["`+logging.Redacted+`"]}`) {
+			t.Error("extra attributes are not redacted:", scanner.Text())
+		}
+	}
+	if lines != 2 {
+		t.Error("expected 2 reviews, got", lines)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "reviews.jsonl")
+	// every review is larger than MaxBytes and goes to its own file
+	sink, err := NewSink(Options{Output: output, MaxBytes: 100, MaxFiles: 2, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		sink.Record(testReview("Namespace", "alice"))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"reviews.jsonl", "reviews.jsonl.1", "reviews.jsonl.2"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(string(content), "\n") != 1 {
+			t.Errorf("%s: expected a single review, got %s", name, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "reviews.jsonl.3")); !os.IsNotExist(err) {
+		t.Error("more than 2 rotated files are kept")
+	}
+}
+
+func TestRecordDropsWhenFull(t *testing.T) {
+	// a sink without a writer goroutine, its buffer holds a single review
+	sink := &Sink{options: Options{SampleRate: 1}, records: make(chan *v1beta1.AdmissionReview, 1)}
+	var before dto.Metric
+	if err := captureDropped.Write(&before); err != nil {
+		t.Fatal(err)
+	}
+	sink.Record(testReview("Namespace", "alice"))
+	sink.Record(testReview("Namespace", "bob"))
+	var after dto.Metric
+	if err := captureDropped.Write(&after); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 || after.GetCounter().GetValue()-before.GetCounter().GetValue() != 1 {
+		t.Errorf("expected one queued and one dropped review, got %d queued", len(sink.records))
+	}
+
+	current.Store(sinkValue{sink})
+	defer current.Store(sinkValue{})
+	if stats, ok := CurrentStats(); !ok || !stats.Enabled || stats.Queued != 1 || stats.Dropped == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// TestConfigureWhileRecording switches the output while reviews are recorded, every review is
+// written to one of the outputs
+func TestConfigureWhileRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outputs := []string{filepath.Join(dir, "a.jsonl"), filepath.Join(dir, "b.jsonl")}
+	if err := Configure(Options{Output: outputs[0], SampleRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = Configure(Options{}) }()
+
+	// the reviews fit in the buffer, none is dropped because it is full
+	const recorders, reviews = 4, 100
+	var wg sync.WaitGroup
+	for i := 0; i < recorders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < reviews; j++ {
+				Record(testReview("Namespace", "alice"))
+			}
+		}()
+	}
+	for i := 1; i <= 20; i++ {
+		if err := Configure(Options{Output: outputs[i%2], SampleRate: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if err := Configure(Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := 0
+	for _, output := range outputs {
+		data, err := ioutil.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines += strings.Count(string(data), "\n")
+	}
+	if lines != recorders*reviews {
+		t.Errorf("expected %d reviews, got %d", recorders*reviews, lines)
+	}
+}
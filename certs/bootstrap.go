@@ -0,0 +1,325 @@
+package certs
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CertKey and KeyKey are the serving certificate and key in the Secret, the files mounted by the Deployment
+	CertKey = "cert.pem"
+	KeyKey  = "key.pem"
+	// CAKey is the CA bundle of the webhook configurations
+	CAKey = "ca.pem"
+	// CAKeyKey is the key of the self-signed CA
+	CAKeyKey = "ca-key.pem"
+
+	// retryInterval is the interval for retrying a failed renewal
+	retryInterval = time.Minute
+	// resyncInterval is the interval for checking the Secret and the caBundle between renewals
+	resyncInterval = 10 * time.Minute
+	// csrTimeout is the time to wait for a requested certificate
+	csrTimeout = 5 * time.Minute
+)
+
+var certificateExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bhadmission_serving_certificate_expiry_timestamp_seconds",
+	Help: "The time the serving certificate expires",
+})
+
+// Bootstrapper keeps the serving certificate of the webhook in a Secret, renews it before it
+// expires and keeps the caBundle of the webhook configurations in sync
+type Bootstrapper struct {
+	Client kubernetes.Interface
+	// Dynamic is used for the CSR API in ModeCSR
+	Dynamic dynamic.Interface
+	// Mode is ModeSelfSigned or ModeCSR
+	Mode      string
+	Namespace string
+	// Service of the webhook, the certificate is issued for its DNS names
+	Service string
+	Secret  string
+	// SignerName of the CertificateSigningRequest in ModeCSR
+	SignerName string
+	// Approve the CertificateSigningRequest in ModeCSR
+	Approve bool
+	// CABundle of the signer in ModeCSR
+	CABundle    []byte
+	Validity    time.Duration
+	RenewBefore time.Duration
+	// Keeper receives the current certificate, may be nil
+	Keeper *Keeper
+	// OnRotate is called with the caBundle for the first certificate and after every renewal, may be nil
+	OnRotate func(caBundle []byte) error
+	// rotated is set once OnRotate succeeded for the current certificate
+	rotated bool
+}
+
+// Ensure issues a certificate when the Secret has none or it is about to expire, updates the
+// caBundle of the webhook configurations and returns the time of the next renewal
+func (b *Bootstrapper) Ensure() (time.Time, error) {
+	secrets := b.Client.CoreV1().Secrets(b.Namespace)
+	secret, err := secrets.Get(b.Secret, metav1.GetOptions{})
+	exists := err == nil
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: b.Secret, Namespace: b.Namespace}}
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	if b.needsRenewal(secret.Data) {
+		if next, ok, err := b.nextCA(secret.Data); err != nil {
+			return time.Time{}, err
+		} else if ok {
+			// the bundle with both CAs is published before the new CA signs the served certificate,
+			// so that the API server trusts the certificates of every replica while they switch
+			secret.Data = next
+			if secret, err = secrets.Update(secret); err != nil {
+				return time.Time{}, err
+			}
+			logrus.Infoln("Stored a new CA next to the current one in secret", b.Namespace+"/"+b.Secret)
+			b.rotated = false
+			if err := b.publishCABundle(secret.Data[CAKey]); err != nil {
+				return time.Time{}, err
+			}
+		}
+		data, err := b.issue(secret.Data)
+		if err != nil {
+			return time.Time{}, err
+		}
+		secret.Data = data
+		if exists {
+			secret, err = secrets.Update(secret)
+		} else {
+			secret, err = secrets.Create(secret)
+		}
+		if err != nil {
+			// another replica may have renewed the certificate, the next attempt reads it
+			return time.Time{}, err
+		}
+		logrus.Infoln("Stored a new serving certificate in secret", b.Namespace+"/"+b.Secret)
+		b.rotated = false
+	}
+
+	pair, err := ParseKeyPair(secret.Data[CertKey], secret.Data[KeyKey])
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the certificate is only served once the API server trusts it
+	if err := b.publishCABundle(secret.Data[CAKey]); err != nil {
+		return time.Time{}, err
+	}
+	if b.Keeper != nil {
+		if err := b.Keeper.Set(secret.Data[CertKey], secret.Data[KeyKey]); err != nil {
+			return time.Time{}, err
+		}
+	}
+	certificateExpiry.Set(float64(pair.Cert.NotAfter.Unix()))
+	renewAt := pair.Cert.NotAfter.Add(-b.RenewBefore)
+	if b.Mode == ModeSelfSigned {
+		if ca, err := ParseKeyPair(secret.Data[CAKey], secret.Data[CAKeyKey]); err == nil && ca.Cert.NotAfter.Add(-b.RenewBefore).Before(renewAt) {
+			renewAt = ca.Cert.NotAfter.Add(-b.RenewBefore)
+		}
+	}
+	return renewAt, nil
+}
+
+// publishCABundle sets the caBundle of the webhook configurations and calls OnRotate once per bundle
+func (b *Bootstrapper) publishCABundle(caBundle []byte) error {
+	if err := SyncCABundle(b.Client, b.Service, b.Namespace, caBundle); err != nil {
+		return err
+	}
+	if b.OnRotate != nil && !b.rotated {
+		if err := b.OnRotate(caBundle); err != nil {
+			return err
+		}
+		b.rotated = true
+	}
+	return nil
+}
+
+// nextCA returns the Secret data with a new self-signed CA added to the bundle when the CA is about to
+// expire. The serving certificate of the current CA is kept until the bundle is published. ok is false
+// when the CA is kept or there is no serving certificate to keep.
+func (b *Bootstrapper) nextCA(current map[string][]byte) (map[string][]byte, bool, error) {
+	if b.Mode != ModeSelfSigned {
+		return nil, false, nil
+	}
+	ca, err := ParseKeyPair(current[CAKey], current[CAKeyKey])
+	if err != nil || !ca.Expires(time.Now().Add(b.RenewBefore)) {
+		return nil, false, nil
+	}
+	pair, err := ParseKeyPair(current[CertKey], current[KeyKey])
+	if err != nil || pair.Expires(time.Now()) || pair.Cert.CheckSignatureFrom(ca.Cert) != nil {
+		return nil, false, nil
+	}
+	// the CA outlives several serving certificates
+	next, err := NewCA(b.Service+"-ca", 10*b.Validity)
+	if err != nil {
+		return nil, false, err
+	}
+	logrus.Infoln("Created a self-signed CA valid until", next.Cert.NotAfter)
+	return map[string][]byte{
+		CertKey: current[CertKey],
+		KeyKey:  current[KeyKey],
+		// the previous CA stays in the bundle until it expires
+		CAKey:    Bundle(next.CertPEM, ca.CertPEM),
+		CAKeyKey: next.KeyPEM,
+	}, true, nil
+}
+
+// needsRenewal tells if the Secret data has no valid certificate for the service
+func (b *Bootstrapper) needsRenewal(data map[string][]byte) bool {
+	pair, err := ParseKeyPair(data[CertKey], data[KeyKey])
+	if err != nil {
+		return true
+	}
+	renewAt := time.Now().Add(b.RenewBefore)
+	if pair.Expires(renewAt) || !pair.Covers(DNSNames(b.Service, b.Namespace)) || len(data[CAKey]) == 0 {
+		return true
+	}
+	if b.Mode == ModeSelfSigned {
+		ca, err := ParseKeyPair(data[CAKey], data[CAKeyKey])
+		if err != nil || ca.Expires(renewAt) || pair.Cert.CheckSignatureFrom(ca.Cert) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// issue returns the Secret data with a new serving certificate
+func (b *Bootstrapper) issue(current map[string][]byte) (map[string][]byte, error) {
+	dnsNames := DNSNames(b.Service, b.Namespace)
+	switch b.Mode {
+	case ModeSelfSigned:
+		ca, err := ParseKeyPair(current[CAKey], current[CAKeyKey])
+		previousCA := []byte(nil)
+		if err != nil || ca.Expires(time.Now().Add(b.RenewBefore)) {
+			if ca != nil {
+				// the previous CA stays in the bundle until it expires
+				previousCA = ca.CertPEM
+			}
+			// the CA outlives several serving certificates
+			if ca, err = NewCA(b.Service+"-ca", 10*b.Validity); err != nil {
+				return nil, err
+			}
+			logrus.Infoln("Created a self-signed CA valid until", ca.Cert.NotAfter)
+		}
+		pair, err := ca.IssueServing(dnsNames, b.Validity)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{
+			CertKey:  pair.CertPEM,
+			KeyKey:   pair.KeyPEM,
+			CAKey:    Bundle(ca.CertPEM, previousCA),
+			CAKeyKey: ca.KeyPEM,
+		}, nil
+	case ModeCSR:
+		pair, err := RequestCertificate(b.Dynamic, CSRRequest{
+			Name:       b.Service + "." + b.Namespace,
+			SignerName: b.SignerName,
+			DNSNames:   dnsNames,
+			Approve:    b.Approve,
+			Timeout:    csrTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(b.CABundle) == 0 {
+			return nil, errors.New("the CA bundle of signer " + b.SignerName + " is required")
+		}
+		return map[string][]byte{
+			CertKey: pair.CertPEM,
+			KeyKey:  pair.KeyPEM,
+			CAKey:   Bundle(b.CABundle),
+		}, nil
+	}
+	return nil, errors.New("unknown certificate mode " + b.Mode)
+}
+
+// Run renews the certificate before it expires until stop is closed
+func (b *Bootstrapper) Run(stop <-chan struct{}) {
+	for {
+		wait := retryInterval
+		renewAt, err := b.Ensure()
+		if err != nil {
+			logrus.Errorln("Failed to renew the serving certificate, retrying in", retryInterval, ":", err)
+		} else if wait = time.Until(renewAt); wait > resyncInterval {
+			wait = resyncInterval
+		} else if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// SyncCABundle sets the caBundle of every webhook calling the service
+func SyncCABundle(client kubernetes.Interface, name, namespace string, caBundle []byte) error {
+	if len(caBundle) == 0 {
+		return nil
+	}
+	matches := func(service *admissionregistrationv1beta1.ServiceReference) bool {
+		return service != nil && service.Name == name && service.Namespace == namespace
+	}
+	mutating := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	mwcs, err := mutating.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range mwcs.Items {
+		mwc := &mwcs.Items[i]
+		changed := false
+		for j := range mwc.Webhooks {
+			config := &mwc.Webhooks[j].ClientConfig
+			if matches(config.Service) && !bytes.Equal(config.CABundle, caBundle) {
+				config.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := mutating.Update(mwc); err != nil {
+				return err
+			}
+			logrus.Infoln("Updated the caBundle of MutatingWebhookConfiguration", mwc.Name)
+		}
+	}
+	validating := client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	vwcs, err := validating.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range vwcs.Items {
+		vwc := &vwcs.Items[i]
+		changed := false
+		for j := range vwc.Webhooks {
+			config := &vwc.Webhooks[j].ClientConfig
+			if matches(config.Service) && !bytes.Equal(config.CABundle, caBundle) {
+				config.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if _, err := validating.Update(vwc); err != nil {
+				return err
+			}
+			logrus.Infoln("Updated the caBundle of ValidatingWebhookConfiguration", vwc.Name)
+		}
+	}
+	return nil
+}
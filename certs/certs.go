@@ -0,0 +1,218 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ModeFile reads the serving certificate from files and reloads them when they change
+	ModeFile = "file"
+	// ModeSelfSigned keeps a self-signed CA and serving certificate in a Secret
+	ModeSelfSigned = "self-signed"
+	// ModeCSR requests the serving certificate through the certificates.k8s.io API
+	ModeCSR = "csr"
+)
+
+// keySize of the generated RSA keys
+const keySize = 2048
+
+// KeyPair is a certificate and its private key
+type KeyPair struct {
+	Cert    *x509.Certificate
+	Key     *rsa.PrivateKey
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// DNSNames returns the names of a service, as used by the API server to call a webhook
+func DNSNames(service, namespace string) []string {
+	return []string{service, service + "." + namespace, service + "." + namespace + ".svc"}
+}
+
+func newKey() (*rsa.PrivateKey, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, keyPEM, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func sign(template, parent *x509.Certificate, key *rsa.PrivateKey, signer *rsa.PrivateKey, keyPEM []byte) (*KeyPair, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		Cert:    cert,
+		Key:     key,
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  keyPEM,
+	}, nil
+}
+
+// NewCA returns a self-signed CA
+func NewCA(commonName string, validity time.Duration) (*KeyPair, error) {
+	key, keyPEM, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return sign(template, template, key, key, keyPEM)
+}
+
+// IssueServing returns a serving certificate for the DNS names signed by the CA.
+// The certificate does not outlive the CA.
+func (ca *KeyPair) IssueServing(dnsNames []string, validity time.Duration) (*KeyPair, error) {
+	key, keyPEM, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[len(dnsNames)-1]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	return sign(template, ca.Cert, key, ca.Key, keyPEM)
+}
+
+// ParseKeyPair parses a PEM certificate and RSA key, the first certificate is the leaf
+func ParseKeyPair(certPEM, keyPEM []byte) (*KeyPair, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no PEM key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if key.PublicKey.N.Cmp(cert.PublicKey.(*rsa.PublicKey).N) != 0 {
+		return nil, errors.New("the key does not match the certificate")
+	}
+	return &KeyPair{Cert: cert, Key: key, CertPEM: certPEM, KeyPEM: keyPEM}, nil
+}
+
+// Covers tells if the certificate is valid for all DNS names
+func (pair *KeyPair) Covers(dnsNames []string) bool {
+	for _, name := range dnsNames {
+		if pair.Cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Expires tells if the certificate expires before the time
+func (pair *KeyPair) Expires(before time.Time) bool {
+	return pair.Cert.NotAfter.Before(before)
+}
+
+// Bundle concatenates PEM certificates, skipping expired and duplicate ones
+func Bundle(certs ...[]byte) []byte {
+	var bundle bytes.Buffer
+	seen := map[string]bool{}
+	for _, content := range certs {
+		for {
+			var block *pem.Block
+			block, content = pem.Decode(content)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" || seen[string(block.Bytes)] {
+				continue
+			}
+			if cert, err := x509.ParseCertificate(block.Bytes); err != nil || cert.NotAfter.Before(time.Now()) {
+				continue
+			}
+			seen[string(block.Bytes)] = true
+			_ = pem.Encode(&bundle, block)
+		}
+	}
+	return bundle.Bytes()
+}
+
+// Keeper serves the latest serving certificate to new TLS connections
+type Keeper struct {
+	cert atomic.Value
+}
+
+// Set replaces the serving certificate
+func (keeper *Keeper) Set(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	keeper.cert.Store(&cert)
+	return nil
+}
+
+// LoadFiles replaces the serving certificate with the certificate and key files
+func (keeper *Keeper) LoadFiles(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	keeper.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (keeper *Keeper) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, ok := keeper.cert.Load().(*tls.Certificate)
+	if !ok {
+		return nil, errors.New("no serving certificate")
+	}
+	return cert, nil
+}
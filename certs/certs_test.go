@@ -0,0 +1,242 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const day = 24 * time.Hour
+
+func TestIssueServing(t *testing.T) {
+	ca, err := NewCA("test-ca", 10*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := ca.IssueServing(DNSNames("bh-admission", "bh-admission"), 365*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pair.Covers([]string{"bh-admission.bh-admission.svc"}) || pair.Covers([]string{"other.bh-admission.svc"}) {
+		t.Error("Unexpected DNS names", pair.Cert.DNSNames)
+	}
+	if pair.Cert.NotAfter.After(ca.Cert.NotAfter) {
+		t.Error("The serving certificate outlives the CA")
+	}
+	if _, err := ParseKeyPair(pair.CertPEM, ca.KeyPEM); err == nil {
+		t.Error("Expected error for a key of another certificate")
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(Bundle(ca.CertPEM))
+	if _, err := pair.Cert.Verify(x509.VerifyOptions{DNSName: "bh-admission.bh-admission.svc", Roots: roots}); err != nil {
+		t.Error(err)
+	}
+
+	keeper := &Keeper{}
+	if _, err := keeper.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("Expected error without certificate")
+	}
+	if err := keeper.Set(pair.CertPEM, pair.KeyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := keeper.GetCertificate(&tls.ClientHelloInfo{}); err != nil || len(cert.Certificate) != 1 {
+		t.Error("Unexpected certificate", err)
+	}
+}
+
+func testWebhooks() []runtime.Object {
+	path := "/mutate"
+	return []runtime.Object{
+		&admissionregistrationv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "bh-admission-mwc"},
+			Webhooks: []admissionregistrationv1beta1.MutatingWebhook{{
+				Name: "bh-admission-mutate.cust.local",
+				ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+					Service: &admissionregistrationv1beta1.ServiceReference{Name: "bh-admission", Namespace: "bh-admission", Path: &path},
+				},
+			}},
+		},
+		&admissionregistrationv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "other"},
+			Webhooks: []admissionregistrationv1beta1.MutatingWebhook{{
+				Name: "other.cust.local",
+				ClientConfig: admissionregistrationv1beta1.WebhookClientConfig{
+					Service: &admissionregistrationv1beta1.ServiceReference{Name: "other", Namespace: "bh-admission", Path: &path},
+				},
+			}},
+		},
+	}
+}
+
+func TestBootstrapperSelfSigned(t *testing.T) {
+	client := fake.NewSimpleClientset(testWebhooks()...)
+	keeper := &Keeper{}
+	b := &Bootstrapper{
+		Client:      client,
+		Mode:        ModeSelfSigned,
+		Namespace:   "bh-admission",
+		Service:     "bh-admission",
+		Secret:      "bh-admission-certs",
+		Validity:    30 * day,
+		RenewBefore: 7 * day,
+		Keeper:      keeper,
+	}
+	rotations := 0
+	b.OnRotate = func(caBundle []byte) error {
+		rotations++
+		return nil
+	}
+	renewAt, err := b.Ensure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewAt.Before(time.Now().Add(22*day)) || renewAt.After(time.Now().Add(23*day)) {
+		t.Error("Unexpected renewal time", renewAt)
+	}
+	secret, err := client.CoreV1().Secrets("bh-admission").Get("bh-admission-certs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keeper.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Error(err)
+	}
+	mwc, _ := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{})
+	if !bytes.Equal(mwc.Webhooks[0].ClientConfig.CABundle, secret.Data[CAKey]) {
+		t.Error("The caBundle was not synced")
+	}
+	other, _ := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get("other", metav1.GetOptions{})
+	if len(other.Webhooks[0].ClientConfig.CABundle) > 0 {
+		t.Error("The caBundle of another service was changed")
+	}
+
+	// a valid certificate is kept
+	if _, err := b.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	kept, _ := client.CoreV1().Secrets("bh-admission").Get("bh-admission-certs", metav1.GetOptions{})
+	if !bytes.Equal(kept.Data[CertKey], secret.Data[CertKey]) || rotations != 1 {
+		t.Error("A valid certificate was renewed")
+	}
+
+	// a certificate about to expire is renewed with the same CA
+	b.RenewBefore = 31 * day
+	if _, err := b.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	renewed, _ := client.CoreV1().Secrets("bh-admission").Get("bh-admission-certs", metav1.GetOptions{})
+	if bytes.Equal(renewed.Data[CertKey], secret.Data[CertKey]) {
+		t.Error("The certificate was not renewed")
+	}
+	if !bytes.Equal(renewed.Data[CAKey], secret.Data[CAKey]) {
+		t.Error("The CA was replaced before it expires")
+	}
+	if rotations != 2 {
+		t.Error("OnRotate was not called after the renewal")
+	}
+
+	// a CA about to expire is published in the bundle while the certificate of the previous CA is served
+	served := func() []byte {
+		cert, err := keeper.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Certificate[0]
+	}
+	previous, _ := ParseKeyPair(renewed.Data[CAKey], renewed.Data[CAKeyKey])
+	var published [][]byte
+	b.OnRotate = func(caBundle []byte) error {
+		mwc, _ := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{})
+		if !bytes.Equal(mwc.Webhooks[0].ClientConfig.CABundle, caBundle) {
+			t.Error("OnRotate was called before the caBundle was synced")
+		}
+		cert, _ := x509.ParseCertificate(served())
+		if len(published) == 0 && cert.CheckSignatureFrom(previous.Cert) != nil {
+			t.Error("The certificate of the new CA was served before the bundle was published")
+		}
+		published = append(published, caBundle)
+		return nil
+	}
+	// the new CA lives 400 days, the previous one expires within the renewal period
+	b.Validity = 40 * day
+	b.RenewBefore = 301 * day
+	if _, err := b.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := client.CoreV1().Secrets("bh-admission").Get("bh-admission-certs", metav1.GetOptions{})
+	ca, err := ParseKeyPair(rotated.Data[CAKey], rotated.Data[CAKeyKey])
+	if err != nil || bytes.Equal(ca.Cert.Raw, previous.Cert.Raw) {
+		t.Fatal("The CA was not replaced", err)
+	}
+	if len(published) != 2 || !bytes.Equal(published[0], rotated.Data[CAKey]) || !bytes.Contains(rotated.Data[CAKey], previous.CertPEM) {
+		t.Errorf("Expected the bundle of both CAs to be published before and after the switch, got %d bundles", len(published))
+	}
+	cert, _ := x509.ParseCertificate(served())
+	if cert.CheckSignatureFrom(ca.Cert) != nil {
+		t.Error("The certificate of the new CA is not served")
+	}
+}
+
+func TestRequestCertificate(t *testing.T) {
+	ca, err := NewCA("signer", 10*day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	approved := false
+	client.PrependReactor("update", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		approved = action.GetSubresource() == "approval"
+		return false, nil, nil
+	})
+	// the signer issues the certificate on creation
+	client.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		if signer, _, _ := unstructured.NestedString(csr.Object, "spec", "signerName"); signer != "example.com/webhooks" {
+			t.Error("Unexpected signerName", signer)
+		}
+		encoded, _, _ := unstructured.NestedString(csr.Object, "spec", "request")
+		request, _ := base64.StdEncoding.DecodeString(encoded)
+		block, _ := pem.Decode(request)
+		csrRequest, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serving, err := ca.IssueServing(csrRequest.DNSNames, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// replace the generated key by signing the requested public key
+		der, err := x509.CreateCertificate(rand.Reader, serving.Cert, ca.Cert, csrRequest.PublicKey, ca.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		_ = unstructured.SetNestedField(csr.Object, base64.StdEncoding.EncodeToString(certPEM), "status", "certificate")
+		return false, nil, nil
+	})
+	pair, err := RequestCertificate(client, CSRRequest{
+		Name:       "bh-admission.bh-admission",
+		SignerName: "example.com/webhooks",
+		DNSNames:   DNSNames("bh-admission", "bh-admission"),
+		Approve:    true,
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pair.Covers([]string{"bh-admission.bh-admission.svc"}) || !approved {
+		t.Error("Unexpected certificate or request not approved")
+	}
+}
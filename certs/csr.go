@@ -0,0 +1,129 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// csrResource is the v1 CertificateSigningRequest API, which requires a signerName.
+// It is used through the dynamic client because the vendored types predate signerName.
+var csrResource = schema.GroupVersionResource{Group: "certificates.k8s.io", Version: "v1", Resource: "certificatesigningrequests"}
+
+// csrPollInterval is the interval for checking if the certificate was issued
+const csrPollInterval = time.Second
+
+// CSRRequest describes a serving certificate requested through the CSR API
+type CSRRequest struct {
+	// Name of the CertificateSigningRequest
+	Name       string
+	SignerName string
+	DNSNames   []string
+	// Approve the request, requires the approve verb on the signer
+	Approve bool
+	// Timeout for the certificate to be issued
+	Timeout time.Duration
+}
+
+// RequestCertificate creates a CertificateSigningRequest for a new key and waits for the certificate
+func RequestCertificate(client dynamic.Interface, request CSRRequest) (*KeyPair, error) {
+	if len(request.SignerName) == 0 {
+		return nil, errors.New("a signerName is required")
+	}
+	key, keyPEM, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: request.DNSNames[len(request.DNSNames)-1]},
+		DNSNames: request.DNSNames,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	csrs := client.Resource(csrResource)
+	// a previous request with the same name can't be reused, its certificate belongs to another key
+	if err := csrs.Delete(request.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	csr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "certificates.k8s.io/v1",
+		"kind":       "CertificateSigningRequest",
+		"metadata":   map[string]interface{}{"name": request.Name},
+		"spec": map[string]interface{}{
+			"request":    base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+			"signerName": request.SignerName,
+			"usages":     []interface{}{"digital signature", "key encipherment", "server auth"},
+		},
+	}}
+	if csr, err = csrs.Create(csr, metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	logrus.Infoln("Created CertificateSigningRequest", request.Name, "for signer", request.SignerName)
+	if request.Approve {
+		conditions := []interface{}{map[string]interface{}{
+			"type":    "Approved",
+			"status":  "True",
+			"reason":  "BhAdmissionApprove",
+			"message": "serving certificate of the webhook",
+		}}
+		if err := unstructured.SetNestedSlice(csr.Object, conditions, "status", "conditions"); err != nil {
+			return nil, err
+		}
+		if _, err := csrs.Update(csr, metav1.UpdateOptions{}, "approval"); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(request.Timeout)
+	for {
+		csr, err := csrs.Get(request.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if denied := csrCondition(csr, "Denied", "Failed"); len(denied) > 0 {
+			return nil, errors.New("CertificateSigningRequest " + request.Name + " " + denied)
+		}
+		encoded, _, _ := unstructured.NestedString(csr.Object, "status", "certificate")
+		if len(encoded) > 0 {
+			certPEM, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, err
+			}
+			return ParseKeyPair(certPEM, keyPEM)
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("CertificateSigningRequest " + request.Name + " was not issued, is it approved and is the signer running?")
+		}
+		time.Sleep(csrPollInterval)
+	}
+}
+
+// csrCondition returns the first of the condition types with status True
+func csrCondition(csr *unstructured.Unstructured, types ...string) string {
+	conditions, _, _ := unstructured.NestedSlice(csr.Object, "status", "conditions")
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]interface{})
+		if !ok || fields["status"] != "True" {
+			continue
+		}
+		for _, conditionType := range types {
+			if fields["type"] == conditionType {
+				message, _ := fields["message"].(string)
+				return conditionType + ": " + message
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/server"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// propertiesConfigMapKey and resourceRulesConfigMapKey are the files of the bh-admission-config ConfigMap
+	propertiesConfigMapKey    = "bh-admission.properties"
+	resourceRulesConfigMapKey = "resource-rules.yaml"
+)
+
+// extractConfigMap writes the files of a bh-admission-config ConfigMap to a temporary directory
+func extractConfigMap(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal(data, &configMap); err != nil {
+		return "", err
+	}
+	if configMap.Kind != "ConfigMap" {
+		return "", errors.New("expected a ConfigMap")
+	}
+	if _, ok := configMap.Data[propertiesConfigMapKey]; !ok {
+		return "", errors.New("ConfigMap without " + propertiesConfigMapKey)
+	}
+	dir, err := ioutil.TempDir("", "check-config")
+	if err != nil {
+		return "", err
+	}
+	for name, content := range configMap.Data {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// loadConfigFile loads a property file or a ConfigMap with its resource rules and builds the routes
+// without connecting to the cluster
+func loadConfigFile(file string, flags *pflag.FlagSet) (*config.Config, []server.Route, []error) {
+	propertyFile := file
+	resourceRulesFile := ""
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".yaml" || ext == ".yml" {
+		dir, err := extractConfigMap(file)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		defer os.RemoveAll(dir)
+		propertyFile = filepath.Join(dir, propertiesConfigMapKey)
+		if _, err := os.Stat(filepath.Join(dir, resourceRulesConfigMapKey)); err == nil {
+			resourceRulesFile = filepath.Join(dir, resourceRulesConfigMapKey)
+		}
+	}
+	settings, err := config.Read(propertyFile, flags)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	if len(resourceRulesFile) > 0 && (flags == nil || !flags.Changed(config.FlagName(config.ResourceRulesFileKey))) {
+		settings.Set(config.ResourceRulesFileKey, resourceRulesFile)
+	}
+	if errs := config.Validate(settings); len(errs) > 0 {
+		return nil, nil, errs
+	}
+	cfg, err := config.Load(settings)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	c := &clients{}
+	routes, err := c.newRoutes(cfg)
+	if err != nil {
+		return nil, nil, []error{errors.New("invalid routes: " + err.Error())}
+	}
+	return cfg, routes, nil
+}
+
+// checkConfig validates a property file or a ConfigMap with its resource rules and routes, and returns
+// the warnings of the adjusted settings
+func checkConfig(file string, flags *pflag.FlagSet) ([]string, []error) {
+	cfg, _, errs := loadConfigFile(file, flags)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg.Warnings, nil
+}
+
+// runCheckConfig implements the check-config command and returns the exit code
+func runCheckConfig(args []string) int {
+	flags := newFlagSet("check-config")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: check-config [flags] <bh-admission.properties or ConfigMap YAML>")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	args = flags.Args()
+	if len(args) != 1 {
+		flags.Usage()
+		return 2
+	}
+	warnings, errs := checkConfig(args[0], flags)
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, args[0]+": warning:", warning)
+	}
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, args[0]+":", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Println(args[0] + ": configuration is valid")
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name       string
+		properties string
+		warnings   int
+		errors     int
+	}{
+		{"valid", "external_api_url=https://external-api/register\nroutes=/mutate=owner-annotation;/validate=naming-policy", 0, 0},
+		{"lowered timeout", "external_api_timeout=12", 1, 0},
+		{"invalid keys", "external_api_timeout=0\nexternal_api_url=external-api\nunknown=1", 0, 3},
+		{"invalid route", "routes=/validate=owner-annotation", 0, 1},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, "bh-admission.properties")
+		properties := test.properties + "\nresource_rules_file=" + filepath.Join(dir, "resource-rules.yaml")
+		if err := ioutil.WriteFile(path, []byte(properties), 0644); err != nil {
+			t.Fatal(err)
+		}
+		warnings, errs := checkConfig(path, nil)
+		if len(errs) != test.errors || len(warnings) != test.warnings {
+			t.Errorf("%s: expected %d errors and %d warnings, got %v %q", test.name, test.errors, test.warnings, errs, warnings)
+		}
+	}
+	if _, errs := checkConfig(filepath.Join(dir, "missing.properties"), nil); len(errs) != 1 {
+		t.Error("Expected error for missing file")
+	}
+	if warnings, errs := checkConfig("configmap.yaml", nil); len(errs) > 0 || len(warnings) > 0 {
+		t.Error("Invalid configmap.yaml:", errs, warnings)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"namespace-admission-controller/config"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
+// command is a subcommand of the binary, run returns the exit code
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// defaultCommand runs when the first argument is not a command
+const defaultCommand = "serve"
+
+func commands() []command {
+	return []command{
+		{name: "serve", summary: "run the webhook, the default command", run: runServe},
+		{name: "check-config", summary: "validate a bh-admission.properties file or ConfigMap", run: runCheckConfig},
+		{name: "gen-manifests", summary: "print or apply the RBAC, Deployment and webhook configurations", run: runGenManifests},
+		{name: "gen-certs", summary: "create or renew the serving certificate and the caBundle", run: runGenCerts},
+		{name: "replay", summary: "run AdmissionReviews from a JSONL file against fake clients", run: runReplay},
+		{name: "loadtest", summary: "fire a mix of AdmissionReviews at a local server with a slow stub external API", run: runLoadTest},
+		{name: "uninstall", summary: "remove the webhook configurations", run: runUninstall},
+		{name: "version", summary: "print the version", run: runVersion},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: "+os.Args[0]+" [command] [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun '"+os.Args[0]+" <command> --help' for the flags of a command.")
+	fmt.Fprintln(os.Stderr, "Settings are taken from the flags, the environment, the property file and the defaults, in this order.")
+}
+
+// newFlagSet returns the flags of a command with a flag for every configuration key
+func newFlagSet(name string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.SortFlags = false
+	config.AddFlags(flags)
+	return flags
+}
+
+// parseFlags parses the arguments and returns the exit code when the command should not run
+func parseFlags(flags *pflag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0, false
+		}
+		return 2, false
+	}
+	return 0, true
+}
+
+func runServe(args []string) int {
+	flags := newFlagSet("serve")
+	propertyFile := flags.String("config", config.DefaultPropertyFile, "property file")
+	tlsCert := flags.String("tls-cert", TLSCert, "TLS certificate of the webhook listener")
+	tlsKey := flags.String("tls-key", TLSKey, "TLS key of the webhook listener")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "unexpected arguments:", strings.Join(flags.Args(), " "))
+		return 2
+	}
+	serve(*propertyFile, *tlsCert, *tlsKey, flags)
+	return 0
+}
+
+func runVersion(args []string) int {
+	fmt.Println(version, runtime.Version())
+	return 0
+}
+
+func main() {
+	args := os.Args[1:]
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+	if name == "help" {
+		usage()
+		return
+	}
+	for _, c := range commands() {
+		if c.name == name {
+			os.Exit(c.run(args))
+		}
+	}
+	fmt.Fprintln(os.Stderr, "unknown command "+name)
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,300 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"namespace-admission-controller/capture"
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/logging"
+	"namespace-admission-controller/server"
+	"namespace-admission-controller/webhook"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultPropertyFile is the property file mounted from the bh-admission-config ConfigMap
+const DefaultPropertyFile = "/etc/webhook/bh-admission-config/bh-admission.properties"
+
+const (
+	// TLSProfileSourceStatic uses the tls_* keys
+	TLSProfileSourceStatic = "static"
+	// TLSProfileSourceOpenShift follows the tlsSecurityProfile of the APIServer configuration
+	TLSProfileSourceOpenShift = "openshift"
+)
+
+// ResourceRulesFileKey is the key of the resource rules file
+const ResourceRulesFileKey = "resource_rules_file"
+
+const (
+	listenAddrKey          = "listen_addr"
+	metricsAddrKey         = "metrics_addr"
+	externalAPIURLKey      = "external_api_url"
+	externalAPITimeoutKey  = "external_api_timeout"
+	externalAPIModeKey     = "external_api_mode"
+	externalAPIFailureKey  = "external_api_failure_policy"
+	requesterKey           = "requester_key"
+	labelsKey              = "labels"
+	labelValueModeKey      = "label_value_mode"
+	pluginsKey             = "plugins"
+	routesKey              = "routes"
+	maxRequestBytesKey     = "max_request_body_bytes"
+	clientCAFileKey        = "client_ca_file"
+	allowedClientNamesKey  = "allowed_client_names"
+	tlsMinVersionKey       = "tls_min_version"
+	tlsMaxVersionKey       = "tls_max_version"
+	tlsCipherSuitesKey     = "tls_cipher_suites"
+	tlsCurvePreferencesKey = "tls_curve_preferences"
+	http2EnabledKey        = "http2_enabled"
+	tlsProfileSourceKey    = "tls_profile_source"
+	logFormatKey           = "log_format"
+	logLevelKey            = "log_level"
+	logBodyKey             = "log_body"
+	logBodySampleRateKey   = "log_body_sample_rate"
+	logRedactPathsKey      = "log_redact_paths"
+	captureOutputKey       = "capture_output"
+	captureMaxSizeKey      = "capture_max_size_mb"
+	captureMaxFilesKey     = "capture_max_files"
+	captureSampleRateKey   = "capture_sample_rate"
+	captureKindsKey        = "capture_kinds"
+	captureUsersKey        = "capture_users"
+	captureRedactPathsKey  = "capture_redact_paths"
+	adminAddrKey           = "admin_addr"
+	adminTokenKey          = "admin_token"
+	namingPolicyKey        = "naming_policy_pattern"
+	maxNamespacesKey       = "max_namespaces_per_owner"
+	quotaFailureKey        = "quota_failure_policy"
+	clusterNameKey         = "cluster_name_key"
+	eventsEnabledKey       = "events_enabled"
+	eventsQPSKey           = "events_qps"
+	eventsBurstKey         = "events_burst"
+	certModeKey            = "cert_mode"
+	certSecretKey          = "cert_secret"
+	certServiceKey         = "cert_service"
+	certNamespaceKey       = "cert_namespace"
+	certSignerNameKey      = "cert_signer_name"
+	certApproveKey         = "cert_approve"
+	certCABundleFileKey    = "cert_ca_bundle_file"
+	certValidityKey        = "cert_validity_days"
+	certRenewBeforeKey     = "cert_renew_before_days"
+	webhookRegistrationKey = "webhook_registration"
+	webhookTimeoutKey      = "webhook_timeout_seconds"
+	namespaceSelectorKey   = "webhook_namespace_selector"
+	objectSelectorKey      = "webhook_object_selector"
+	debugKey               = "debug"
+)
+
+// Config is the validated configuration of the webhook
+type Config struct {
+	ListenAddr  string
+	MetricsAddr string
+	AdminAddr   string
+	AdminToken  string
+
+	ExternalAPIURL           string
+	ExternalAPITimeout       int32
+	ExternalAPIMode          string
+	ExternalAPIFailurePolicy string
+	RequesterKey             string
+	Labels                   map[string]string
+	LabelValueMode           string
+	ResourceRulesFile        string
+	ResourceRules            []webhook.ResourceRule
+	NamingPolicy             *regexp.Regexp
+	MaxNamespacesPerOwner    int
+	QuotaFailurePolicy       string
+	// ClusterName is empty when it is detected from the API server URL
+	ClusterName string
+	// Events configures the events of registrations, failures and denials
+	Events webhook.EventOptions
+
+	// Routes is the route specification, path=plugin,plugin;path=plugin
+	Routes          string
+	MaxRequestBytes int64
+
+	ClientCAFile       string
+	AllowedClientNames []string
+	TLSProfileSource   string
+	TLS                server.TLSOptions
+
+	LogFormat string
+	LogLevel  string
+	LogBody   logging.BodyOptions
+	// Capture writes the AdmissionReviews with their responses for the replay command
+	Capture capture.Options
+
+	// CertMode selects how the serving certificate is provided, see the certs package
+	CertMode    string
+	CertSecret  string
+	CertService string
+	// CertNamespace is empty for the namespace of the webhook
+	CertNamespace    string
+	CertSignerName   string
+	CertApprove      bool
+	CertCABundleFile string
+	CertValidity     time.Duration
+	CertRenewBefore  time.Duration
+
+	// WebhookRegistration lets the webhook create and update its webhook configurations
+	WebhookRegistration bool
+	WebhookTimeout      int32
+	// NamespaceSelector and ObjectSelector of the webhooks, nil selects everything
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+
+	// Warnings lists the settings that were accepted and adjusted
+	Warnings []string
+}
+
+// Read returns the defaults overridden by the property file, the environment and the flags, in this order.
+// Flags may be nil. The returned error tells that the property file could not be read, the defaults are still usable.
+func Read(propertyFile string, flags *pflag.FlagSet) (*viper.Viper, error) {
+	v := viper.New()
+	SetDefaults(v)
+	v.AutomaticEnv()
+	if flags != nil {
+		if err := bindFlags(v, flags); err != nil {
+			return v, err
+		}
+	}
+	v.SetConfigFile(propertyFile)
+	return v, v.ReadInConfig()
+}
+
+func invalid(key string, err error) error {
+	return errors.New("invalid " + key + ": " + err.Error())
+}
+
+// parseSelector parses a label selector such as "env=build,!excluded", nil when empty
+func parseSelector(selector string) (*metav1.LabelSelector, error) {
+	if len(strings.TrimSpace(selector)) == 0 {
+		return nil, nil
+	}
+	return metav1.ParseToLabelSelector(selector)
+}
+
+// Load validates the settings and returns the configuration
+func Load(v *viper.Viper) (*Config, error) {
+	if errs := Validate(v); len(errs) > 0 {
+		messages := []string{}
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		return nil, errors.New(strings.Join(messages, "; "))
+	}
+	var err error
+	config := &Config{
+		ListenAddr:               v.GetString(listenAddrKey),
+		MetricsAddr:              v.GetString(metricsAddrKey),
+		AdminAddr:                v.GetString(adminAddrKey),
+		AdminToken:               v.GetString(adminTokenKey),
+		ExternalAPIURL:           v.GetString(externalAPIURLKey),
+		ExternalAPITimeout:       v.GetInt32(externalAPITimeoutKey),
+		ExternalAPIMode:          v.GetString(externalAPIModeKey),
+		ExternalAPIFailurePolicy: v.GetString(externalAPIFailureKey),
+		LabelValueMode:           v.GetString(labelValueModeKey),
+		TLSProfileSource:         v.GetString(tlsProfileSourceKey),
+		LogFormat:                v.GetString(logFormatKey),
+		RequesterKey:             v.GetString(requesterKey),
+		ResourceRulesFile:        v.GetString(ResourceRulesFileKey),
+		MaxNamespacesPerOwner:    v.GetInt(maxNamespacesKey),
+		QuotaFailurePolicy:       v.GetString(quotaFailureKey),
+		ClusterName:              v.GetString(clusterNameKey),
+		Routes:                   v.GetString(routesKey),
+		MaxRequestBytes:          v.GetInt64(maxRequestBytesKey),
+		ClientCAFile:             v.GetString(clientCAFileKey),
+		TLS: server.TLSOptions{
+			MinVersion:       v.GetString(tlsMinVersionKey),
+			MaxVersion:       v.GetString(tlsMaxVersionKey),
+			CipherSuites:     strings.Split(v.GetString(tlsCipherSuitesKey), ","),
+			CurvePreferences: strings.Split(v.GetString(tlsCurvePreferencesKey), ","),
+			HTTP2:            v.GetBool(http2EnabledKey),
+		},
+		LogLevel:            v.GetString(logLevelKey),
+		CertMode:            v.GetString(certModeKey),
+		CertSecret:          v.GetString(certSecretKey),
+		CertService:         v.GetString(certServiceKey),
+		CertNamespace:       v.GetString(certNamespaceKey),
+		CertSignerName:      v.GetString(certSignerNameKey),
+		CertApprove:         v.GetBool(certApproveKey),
+		CertCABundleFile:    v.GetString(certCABundleFileKey),
+		CertValidity:        time.Duration(v.GetInt(certValidityKey)) * 24 * time.Hour,
+		CertRenewBefore:     time.Duration(v.GetInt(certRenewBeforeKey)) * 24 * time.Hour,
+		WebhookRegistration: v.GetBool(webhookRegistrationKey),
+		WebhookTimeout:      v.GetInt32(webhookTimeoutKey),
+		LogBody: logging.BodyOptions{
+			Enabled:     v.GetBool(logBodyKey),
+			SampleRate:  v.GetFloat64(logBodySampleRateKey),
+			RedactPaths: strings.Split(v.GetString(logRedactPathsKey), ","),
+		},
+		Events: webhook.EventOptions{
+			Enabled: v.GetBool(eventsEnabledKey),
+			QPS:     float32(v.GetFloat64(eventsQPSKey)),
+			Burst:   v.GetInt(eventsBurstKey),
+		},
+		Capture: capture.Options{
+			Output:      v.GetString(captureOutputKey),
+			MaxBytes:    v.GetInt64(captureMaxSizeKey) * 1024 * 1024,
+			MaxFiles:    v.GetInt(captureMaxFilesKey),
+			SampleRate:  v.GetFloat64(captureSampleRateKey),
+			Kinds:       strings.Split(v.GetString(captureKindsKey), ","),
+			Users:       strings.Split(v.GetString(captureUsersKey), ","),
+			RedactPaths: strings.Split(v.GetString(captureRedactPathsKey), ","),
+		},
+	}
+	if len(config.Routes) == 0 {
+		// a single route for all paths
+		config.Routes = "/=" + v.GetString(pluginsKey)
+	}
+	if len(config.ClientCAFile) > 0 {
+		config.AllowedClientNames = strings.Split(v.GetString(allowedClientNamesKey), ",")
+	}
+	if v.GetBool(debugKey) {
+		config.LogLevel = "debug"
+	}
+
+	if config.Labels, err = webhook.ParseLabels(v.GetString(labelsKey)); err != nil {
+		return nil, invalid(labelsKey, err)
+	}
+	if config.MaxNamespacesPerOwner > 0 && len(webhook.OwnerLabel(config.Labels)) == 0 {
+		return nil, invalid(maxNamespacesKey, errors.New("requires a label with the value {requester} in "+labelsKey))
+	}
+	if config.ResourceRules, err = webhook.LoadResourceRules(config.ResourceRulesFile); err != nil {
+		return nil, invalid(ResourceRulesFileKey, err)
+	}
+	if pattern := v.GetString(namingPolicyKey); len(pattern) > 0 {
+		if config.NamingPolicy, err = regexp.Compile(pattern); err != nil {
+			return nil, invalid(namingPolicyKey, err)
+		}
+	}
+	if config.CertMode == certs.ModeCSR && len(config.CertSignerName) == 0 {
+		return nil, invalid(certSignerNameKey, errors.New("value is required with cert_mode="+certs.ModeCSR))
+	}
+	if config.CertRenewBefore >= config.CertValidity {
+		return nil, invalid(certRenewBeforeKey, errors.New("must be less than "+certValidityKey))
+	}
+	if config.NamespaceSelector, err = parseSelector(v.GetString(namespaceSelectorKey)); err != nil {
+		return nil, invalid(namespaceSelectorKey, err)
+	}
+	if config.ObjectSelector, err = parseSelector(v.GetString(objectSelectorKey)); err != nil {
+		return nil, invalid(objectSelectorKey, err)
+	}
+	if _, err := config.TLS.TLSConfig(); err != nil {
+		return nil, invalid("TLS configuration", err)
+	}
+	// the API server would apply the failure policy of the webhook before the external API failure policy.
+	// Older configurations with a longer timeout are still accepted.
+	if config.ExternalAPITimeout >= config.WebhookTimeout {
+		timeout := config.WebhookTimeout - 1
+		if timeout < 1 {
+			timeout = 1
+		}
+		config.Warnings = append(config.Warnings, fmt.Sprintf("%s=%d is lowered to %d, it must be less than %s=%d",
+			externalAPITimeoutKey, config.ExternalAPITimeout, timeout, webhookTimeoutKey, config.WebhookTimeout))
+		config.ExternalAPITimeout = timeout
+	}
+	return config, nil
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeProperties(t *testing.T, dir string, content string) string {
+	path := filepath.Join(dir, "bh-admission.properties")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		properties string
+		valid      bool
+	}{
+		{"defaults", "", true},
+		{"gate", "external_api_mode=gate\nexternal_api_url=https://external-api/register\nexternal_api_failure_policy=Fail\nlabels=owner={requester}", true},
+		{"gate without URL", "external_api_mode=gate", false},
+		{"invalid mode", "external_api_mode=block", false},
+		{"invalid policy", "external_api_failure_policy=Retry", false},
+		{"invalid label value mode", "label_value_mode=base64", false},
+		{"invalid labels", "labels=owner", false},
+		{"invalid naming policy", "naming_policy_pattern=[a-", false},
+		{"invalid sample rate", "log_body_sample_rate=2", false},
+		{"capture", "capture_output=-\ncapture_kinds=Namespace\ncapture_sample_rate=0.1", true},
+		{"capture file too small", "capture_max_size_mb=0", false},
+		{"unknown key", "external_api_timout=10", false},
+		{"selectors", "webhook_namespace_selector=!openshift.io/run-level,env in (build,test)\nwebhook_object_selector=owner", true},
+		{"invalid selector", "webhook_namespace_selector=env in build", false},
+		{"quota", "max_namespaces_per_owner=5\nlabels=bnhp.cloudia/owner={requester},env=build", true},
+		{"quota failure policy", "quota_failure_policy=Fail", true},
+		{"invalid quota failure policy", "quota_failure_policy=Deny", false},
+		{"quota without owner label", "max_namespaces_per_owner=5\nlabels=env=build", false},
+		{"csr", "cert_mode=csr\ncert_signer_name=example.com/webhooks", true},
+		{"csr without signer", "cert_mode=csr", false},
+		{"renewal after expiry", "cert_validity_days=10\ncert_renew_before_days=30", false},
+		{"zero timeout", "external_api_timeout=0", false},
+		{"timeout is not a number", "external_api_timeout=10s", false},
+		{"external API timeout of the webhook", "external_api_timeout=10\nwebhook_timeout_seconds=10", true},
+		{"external API timeout below the webhook", "external_api_timeout=14\nwebhook_timeout_seconds=15", true},
+		{"relative URL", "external_api_url=external-api/register", false},
+		{"URL without host", "external_api_url=http://", false},
+		{"invalid address", "listen_addr=8080", false},
+		{"empty required address", "metrics_addr=", false},
+		{"invalid bool", "http2_enabled=maybe", false},
+		{"invalid log level", "log_level=loud", false},
+		{"invalid TLS version", "tls_min_version=1.4", false},
+	}
+	for _, test := range tests {
+		path := writeProperties(t, dir, test.properties+"\nresource_rules_file="+filepath.Join(dir, "missing.yaml"))
+		settings, err := Read(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(settings)
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if test.valid && err == nil && cfg.Routes != "/=naming-policy,quota,owner-annotation,external-registration" {
+			t.Errorf("%s: unexpected default routes %s", test.name, cfg.Routes)
+		}
+	}
+}
+
+// TestExternalAPITimeoutLowered loads the timeouts of older configurations, they are lowered below
+// the webhook timeout with a warning instead of being rejected
+func TestExternalAPITimeoutLowered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		properties string
+		timeout    int32
+		warned     bool
+	}{
+		{"", 8, false},
+		{"external_api_timeout=12", 9, true},
+		{"external_api_timeout=10", 9, true},
+		{"external_api_timeout=12\nwebhook_timeout_seconds=30", 12, false},
+		{"external_api_timeout=5\nwebhook_timeout_seconds=1", 1, true},
+	}
+	for _, test := range tests {
+		path := writeProperties(t, dir, test.properties+"\nresource_rules_file="+filepath.Join(dir, "missing.yaml"))
+		settings, err := Read(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(settings)
+		if err != nil {
+			t.Fatalf("%q: %v", test.properties, err)
+		}
+		if cfg.ExternalAPITimeout != test.timeout || (len(cfg.Warnings) > 0) != test.warned {
+			t.Errorf("%q: expected timeout %d and a warning %v, got %d %q", test.properties, test.timeout, test.warned, cfg.ExternalAPITimeout, cfg.Warnings)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeProperties(t, dir, "log_level=info")
+
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go Watch(func() []string { return []string{path} }, 10*time.Millisecond, stop, func() { changed <- struct{}{} })
+
+	time.Sleep(50 * time.Millisecond)
+	writeProperties(t, dir, "log_level=debug")
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Error("Change was not detected")
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	settings, err := Read(writeProperties(t, dir, "external_api_timeout=0\nexternal_api_mode=block\nunknown=1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := Validate(settings); len(errs) != 3 {
+		t.Errorf("Expected 3 errors, got %v", errs)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeProperties(t, dir, "external_api_timeout=5\nexternal_api_mode=gate\nrequester_key=file/requester")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	AddFlags(flags)
+	if err := flags.Parse([]string{"--external-api-timeout=8"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("EXTERNAL_API_TIMEOUT", "7")
+	os.Setenv("EXTERNAL_API_MODE", "notify")
+	defer os.Unsetenv("EXTERNAL_API_TIMEOUT")
+	defer os.Unsetenv("EXTERNAL_API_MODE")
+
+	settings, err := Read(path, flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ExternalAPITimeout != 8 {
+		t.Error("Flag did not override environment, timeout:", cfg.ExternalAPITimeout)
+	}
+	if cfg.ExternalAPIMode != "notify" {
+		t.Error("Environment did not override file, mode:", cfg.ExternalAPIMode)
+	}
+	if cfg.RequesterKey != "file/requester" {
+		t.Error("File did not override default, requester key:", cfg.RequesterKey)
+	}
+	if cfg.ListenAddr != "0.0.0.0:8080" {
+		t.Error("Unexpected default listen address:", cfg.ListenAddr)
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+)
+
+// FlagName returns the command line flag of the key, for example --external-api-url for external_api_url
+func FlagName(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// AddFlags adds a flag for every configuration key
+func AddFlags(flags *pflag.FlagSet) {
+	for _, key := range Schema {
+		name := FlagName(key.Name)
+		usage := key.Description
+		if len(key.Values) > 0 {
+			usage += " (" + strings.Join(key.Values, ", ") + ")"
+		}
+		switch key.Type {
+		case TypeBool:
+			value, _ := strconv.ParseBool(fmt.Sprint(key.Default))
+			flags.Bool(name, value, usage)
+		case TypeInt:
+			value, _ := strconv.ParseInt(fmt.Sprint(key.Default), 10, 64)
+			flags.Int64(name, value, usage)
+		case TypeFloat:
+			value, _ := strconv.ParseFloat(fmt.Sprint(key.Default), 64)
+			flags.Float64(name, value, usage)
+		default:
+			value := ""
+			if key.Default != nil {
+				value = fmt.Sprint(key.Default)
+			}
+			flags.String(name, value, usage)
+		}
+	}
+}
+
+// bindFlags makes the flags set on the command line override the environment and the property file
+func bindFlags(v *viper.Viper, flags *pflag.FlagSet) error {
+	for _, key := range Schema {
+		if flag := flags.Lookup(FlagName(key.Name)); flag != nil {
+			if err := v.BindPFlag(key.Name, flag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/logging"
+	"namespace-admission-controller/server"
+	"namespace-admission-controller/webhook"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of the value of a configuration key
+type Type string
+
+const (
+	// TypeString accepts any value
+	TypeString Type = "string"
+	// TypeInt accepts integers
+	TypeInt Type = "int"
+	// TypeFloat accepts decimal numbers
+	TypeFloat Type = "float"
+	// TypeBool accepts true and false
+	TypeBool Type = "bool"
+	// TypeURL accepts absolute http and https URLs
+	TypeURL Type = "url"
+	// TypeAddress accepts host:port listen addresses
+	TypeAddress Type = "address"
+)
+
+// Key describes a configuration key
+type Key struct {
+	Name        string
+	Type        Type
+	Default     interface{}
+	Description string
+	// Values lists the accepted values, any value when empty
+	Values []string
+	// Min and Max limit numbers when Max is above Min
+	Min float64
+	Max float64
+	// Required keys must not be empty
+	Required bool
+}
+
+// Schema lists every configuration key
+var Schema = []Key{
+	{Name: listenAddrKey, Type: TypeAddress, Default: "0.0.0.0:8080", Required: true, Description: "address of the webhook listener"},
+	{Name: metricsAddrKey, Type: TypeAddress, Default: ":2112", Required: true, Description: "address of the metrics listener"},
+	{Name: adminAddrKey, Type: TypeAddress, Default: "127.0.0.1:8081", Description: "address of the admin endpoint, disabled when empty"},
+	{Name: adminTokenKey, Type: TypeString, Description: "bearer token of the admin endpoint, loopback clients only when empty"},
+	{Name: externalAPIURLKey, Type: TypeURL, Description: "URL of the external API, not invoked when empty"},
+	{Name: externalAPITimeoutKey, Type: TypeInt, Default: 8, Min: 1, Max: 300, Description: "timeout of the external API in seconds, lowered below webhook_timeout_seconds"},
+	{Name: externalAPIModeKey, Type: TypeString, Default: webhook.ExternalAPIModeNotify, Values: []string{webhook.ExternalAPIModeNotify, webhook.ExternalAPIModeGate}, Description: "notify the external API or let it allow or deny requests"},
+	{Name: externalAPIFailureKey, Type: TypeString, Default: webhook.FailurePolicyIgnore, Values: []string{webhook.FailurePolicyIgnore, webhook.FailurePolicyFail}, Description: "gate mode decision when the external API fails"},
+	{Name: requesterKey, Type: TypeString, Default: "company.com/requester", Description: "annotation key of the requester"},
+	{Name: labelsKey, Type: TypeString, Description: "labels added to new objects, key=value,key=value"},
+	{Name: labelValueModeKey, Type: TypeString, Default: webhook.LabelValueModeSanitize, Values: []string{webhook.LabelValueModeSanitize, webhook.LabelValueModeHash}, Description: "conversion of label values"},
+	{Name: ResourceRulesFileKey, Type: TypeString, Default: "/etc/webhook/bh-admission-config/resource-rules.yaml", Description: "file with the resource rules"},
+	{Name: pluginsKey, Type: TypeString, Default: strings.Join(webhook.DefaultPlugins, ","), Description: "plugins of the default route"},
+	{Name: routesKey, Type: TypeString, Description: "routes, path=plugin,plugin;path=plugin"},
+	{Name: maxRequestBytesKey, Type: TypeInt, Default: server.DefaultMaxRequestBytes, Min: 1024, Max: 64 * 1024 * 1024, Description: "maximum size of an AdmissionReview in bytes"},
+	{Name: clientCAFileKey, Type: TypeString, Description: "CA bundle for client certificates, not required when empty"},
+	{Name: allowedClientNamesKey, Type: TypeString, Description: "allowed client certificate names, any name when empty"},
+	{Name: tlsMinVersionKey, Type: TypeString, Default: "1.2", Description: "minimum TLS version"},
+	{Name: tlsMaxVersionKey, Type: TypeString, Description: "maximum TLS version"},
+	{Name: tlsCipherSuitesKey, Type: TypeString, Description: "TLS 1.2 cipher suites, IANA names"},
+	{Name: tlsCurvePreferencesKey, Type: TypeString, Description: "TLS curves, X25519, P256, P384 and P521"},
+	{Name: http2EnabledKey, Type: TypeBool, Default: true, Description: "serve HTTP/2"},
+	{Name: tlsProfileSourceKey, Type: TypeString, Default: TLSProfileSourceStatic, Values: []string{TLSProfileSourceStatic, TLSProfileSourceOpenShift}, Description: "use the tls_* keys or follow the OpenShift APIServer TLS security profile"},
+	{Name: logFormatKey, Type: TypeString, Default: logging.FormatJSON, Values: []string{logging.FormatJSON, logging.FormatText}, Description: "log format"},
+	{Name: logLevelKey, Type: TypeString, Default: "info", Values: []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}, Description: "log level"},
+	{Name: logBodyKey, Type: TypeBool, Default: false, Description: "log full AdmissionReviews at debug level"},
+	{Name: logBodySampleRateKey, Type: TypeFloat, Default: 1.0, Min: 0, Max: 1, Description: "fraction of logged AdmissionReviews"},
+	{Name: logRedactPathsKey, Type: TypeString, Default: strings.Join(logging.DefaultRedactPaths, ","), Description: "redacted fields of logged AdmissionReviews"},
+	{Name: captureOutputKey, Type: TypeString, Description: "file or - for stdout receiving every AdmissionReview with its response as a JSON line, disabled when empty"},
+	{Name: captureMaxSizeKey, Type: TypeInt, Default: 100, Min: 1, Max: 10240, Description: "size in MB of the capture file when it is rotated"},
+	{Name: captureMaxFilesKey, Type: TypeInt, Default: 5, Min: 0, Max: 100, Description: "number of rotated capture files kept"},
+	{Name: captureSampleRateKey, Type: TypeFloat, Default: 1.0, Min: 0, Max: 1, Description: "fraction of captured AdmissionReviews"},
+	{Name: captureKindsKey, Type: TypeString, Description: "captured kinds, for example Namespace,ServiceAccount, all kinds when empty"},
+	{Name: captureUsersKey, Type: TypeString, Description: "captured usernames, all users when empty"},
+	{Name: captureRedactPathsKey, Type: TypeString, Default: strings.Join(logging.DefaultRedactPaths, ","), Description: "redacted fields of captured AdmissionReviews"},
+	{Name: namingPolicyKey, Type: TypeString, Description: "regular expression for new project names"},
+	{Name: maxNamespacesKey, Type: TypeInt, Default: 0, Min: 0, Max: 100000, Description: "maximum number of projects per owner, counted by the {requester} label of labels, unlimited when 0"},
+	{Name: quotaFailureKey, Type: TypeString, Default: webhook.FailurePolicyIgnore, Values: []string{webhook.FailurePolicyIgnore, webhook.FailurePolicyFail}, Description: "quota decision when the namespaces can not be listed"},
+	{Name: clusterNameKey, Type: TypeString, Description: "cluster name, detected from the API server URL when empty"},
+	{Name: eventsEnabledKey, Type: TypeBool, Default: true, Description: "record events of registrations, external API failures, patch errors and denials"},
+	{Name: eventsQPSKey, Type: TypeFloat, Default: 1.0, Min: 0.01, Max: 100, Description: "events recorded per second, the events beyond events_burst are dropped"},
+	{Name: eventsBurstKey, Type: TypeInt, Default: 20, Min: 1, Max: 1000, Description: "events recorded at once before events_qps applies"},
+	{Name: certModeKey, Type: TypeString, Default: certs.ModeFile, Values: []string{certs.ModeFile, certs.ModeSelfSigned, certs.ModeCSR}, Description: "read the serving certificate from files, or keep it in a Secret with a self-signed CA or from the CSR API"},
+	{Name: certSecretKey, Type: TypeString, Default: "bh-admission-certs", Required: true, Description: "Secret of the serving certificate"},
+	{Name: certServiceKey, Type: TypeString, Default: "bh-admission", Required: true, Description: "Service of the webhook, the certificate is issued for its DNS names"},
+	{Name: certNamespaceKey, Type: TypeString, Description: "namespace of the Service and Secret, the namespace of the webhook when empty"},
+	{Name: certSignerNameKey, Type: TypeString, Description: "signerName of the CertificateSigningRequest with cert_mode=csr"},
+	{Name: certApproveKey, Type: TypeBool, Default: false, Description: "approve the CertificateSigningRequest with cert_mode=csr"},
+	{Name: certCABundleFileKey, Type: TypeString, Description: "CA bundle of the signer with cert_mode=csr, the API server client CA when empty"},
+	{Name: certValidityKey, Type: TypeInt, Default: 365, Min: 1, Max: 3650, Description: "validity of a new serving certificate in days"},
+	{Name: certRenewBeforeKey, Type: TypeInt, Default: 30, Min: 1, Max: 365, Description: "days before the expiry when the serving certificate is renewed"},
+	{Name: webhookRegistrationKey, Type: TypeBool, Default: false, Description: "create and update the webhook configurations at startup, after a reload and after a certificate rotation"},
+	{Name: webhookTimeoutKey, Type: TypeInt, Default: 10, Min: 1, Max: 30, Description: "timeout of the webhooks in seconds"},
+	{Name: namespaceSelectorKey, Type: TypeString, Description: "namespaceSelector of the webhooks, for example !openshift.io/run-level, all namespaces when empty"},
+	{Name: objectSelectorKey, Type: TypeString, Description: "objectSelector of the webhooks, all objects when empty"},
+	{Name: debugKey, Type: TypeBool, Default: false, Description: "select the debug log level"},
+}
+
+// schemaKey returns the description of the key
+func schemaKey(name string) (Key, bool) {
+	for _, key := range Schema {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// SetDefaults sets the default values of all keys
+func SetDefaults(v *viper.Viper) {
+	for _, key := range Schema {
+		if key.Default != nil {
+			v.SetDefault(key.Name, key.Default)
+		}
+	}
+}
+
+// Validate checks every setting against the schema and returns all problems
+func Validate(v *viper.Viper) []error {
+	errs := []error{}
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	for _, name := range keys {
+		if _, ok := schemaKey(name); !ok {
+			errs = append(errs, errors.New("unknown key "+name))
+		}
+	}
+	for _, key := range Schema {
+		if err := key.validate(v.Get(key.Name)); err != nil {
+			errs = append(errs, errors.New("invalid "+key.Name+": "+err.Error()))
+		}
+	}
+	// without an external API the gate would allow every request
+	if v.GetString(externalAPIModeKey) == webhook.ExternalAPIModeGate && len(strings.TrimSpace(v.GetString(externalAPIURLKey))) == 0 {
+		errs = append(errs, errors.New("invalid "+externalAPIURLKey+": value is required with "+externalAPIModeKey+"="+webhook.ExternalAPIModeGate))
+	}
+	return errs
+}
+
+func (key *Key) validate(raw interface{}) error {
+	value := ""
+	if raw != nil {
+		value = strings.TrimSpace(fmt.Sprint(raw))
+	}
+	if len(value) == 0 {
+		if key.Required {
+			return errors.New("value is required")
+		}
+		return nil
+	}
+	switch key.Type {
+	case TypeInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New(value + " is not an integer")
+		}
+		return key.validateRange(float64(n), value)
+	case TypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New(value + " is not a number")
+		}
+		return key.validateRange(f, value)
+	case TypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New(value + " is not true or false")
+		}
+	case TypeURL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.New(value + " is not an absolute http or https URL")
+		}
+	case TypeAddress:
+		if _, port, err := net.SplitHostPort(value); err != nil {
+			return err
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return errors.New("invalid port " + port)
+		}
+	}
+	if len(key.Values) > 0 {
+		for _, allowed := range key.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return errors.New(value + ", expected one of " + strings.Join(key.Values, ", "))
+	}
+	return nil
+}
+
+func (key *Key) validateRange(n float64, value string) error {
+	if key.Max > key.Min && (n < key.Min || n > key.Max) {
+		return errors.New(value + " is not between " + strconv.FormatFloat(key.Min, 'g', -1, 64) + " and " + strconv.FormatFloat(key.Max, 'g', -1, 64))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"time"
+)
+
+// digest returns a checksum of the content of the files, missing files are empty
+func digest(paths []string) [sha256.Size]byte {
+	hash := sha256.New()
+	for _, path := range paths {
+		content, _ := ioutil.ReadFile(path)
+		hash.Write([]byte(path))
+		hash.Write(content)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
+
+// Watch calls onChange when the content of the files returned by paths changes, until stop is closed.
+// Polling follows the symbolic links that Kubernetes replaces when a ConfigMap changes.
+func Watch(paths func() []string, interval time.Duration, stop <-chan struct{}, onChange func()) {
+	last := digest(paths())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := digest(paths())
+			if current != last {
+				last = current
+				onChange()
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/server"
+	"namespace-admission-controller/webhook/webhooktest"
+	"net/http"
+	"strings"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+)
+
+// e2eWebhook is the webhook served in-process over TLS as in the cluster, with fake clients
+// and a stub external API
+type e2eWebhook struct {
+	*localWebhook
+	cfg         *config.Config
+	externalAPI *webhooktest.ExternalAPI
+}
+
+func startE2EWebhook(t *testing.T, args ...string) *e2eWebhook {
+	externalAPI := webhooktest.NewExternalAPI()
+	flags := newFlagSet("e2e")
+	args = append([]string{"--labels=" + webhooktest.MatrixLabels, "--external-api-url=" + externalAPI.URL()}, args...)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	cfg, routes, errs := loadConfigFile("configmap.yaml", flags)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	local, err := startLocalWebhook(cfg, routes, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &e2eWebhook{localWebhook: local, cfg: cfg, externalAPI: externalAPI}
+}
+
+func (w *e2eWebhook) stop() {
+	w.localWebhook.stop()
+	w.externalAPI.Close()
+}
+
+// useCluster serves the routes with clients of the cluster
+func (w *e2eWebhook) useCluster(t *testing.T, cluster *webhooktest.Cluster) {
+	c := &clients{webhook: cluster.Clients()}
+	routes, err := c.newRoutes(w.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.handler.Swap(server.NewRouter(routes, w.cfg.MaxRequestBytes))
+}
+
+// postReview sends the review to the path as the API server does and returns the response
+func (w *e2eWebhook) postReview(t *testing.T, path string, review *v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := w.post(path, review.Request.UID, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestE2EMatrix(t *testing.T) {
+	w := startE2EWebhook(t)
+	defer w.stop()
+	for _, c := range webhooktest.Matrix() {
+		w.useCluster(t, c.Cluster())
+		mutation := w.postReview(t, "/mutate/owner", c.Review)
+		if calls := w.externalAPI.Take(); len(calls) > 0 {
+			t.Errorf("%s: unexpected external API calls in mutation %q", c.Name, calls)
+		}
+		validation := w.postReview(t, "/validate", c.Review)
+		if !validation.Allowed || len(validation.Patch) > 0 {
+			t.Errorf("%s: unexpected validation response %+v", c.Name, validation)
+		}
+		if problems := c.Check(mutation, w.externalAPI.Take()); len(problems) > 0 {
+			t.Errorf("%s: %s", c.Name, strings.Join(problems, ", "))
+		}
+	}
+}
+
+func TestE2EExternalAPIGate(t *testing.T) {
+	w := startE2EWebhook(t, "--external-api-mode=gate")
+	defer w.stop()
+	w.externalAPI.Respond(http.StatusOK, `{"allowed": false, "reason": "project was not pre-approved"}`)
+	for _, c := range webhooktest.Matrix() {
+		if c.Name != "regular user/project/oc create" {
+			continue
+		}
+		w.useCluster(t, c.Cluster())
+		response := w.postReview(t, "/validate", c.Review)
+		if response.Allowed || response.Result == nil || response.Result.Message != "project was not pre-approved" {
+			t.Errorf("project was not denied by the external API gate: %+v", response)
+		}
+		if calls := w.externalAPI.Take(); len(calls) != 1 || !strings.Contains(string(calls[0]), `"type":"namespace"`) {
+			t.Errorf("expected a single namespace registration, got %q", calls)
+		}
+		return
+	}
+	t.Fatal("no project case in the matrix")
+}
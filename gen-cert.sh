@@ -1,90 +0,0 @@
-#!/bin/bash
-title="bh-admission"
-
-# choose kubectl or oc
-if kubectl version > /dev/null 2>&1; then
-    KUBECTL="kubectl"
-elif oc version > /dev/null 2>&1;then
-    KUBECTL="oc"
-else
-    echo "Either \"kubectl\" or \"oc\" must be installed"
-    exit 1
-fi
-
-${KUBECTL} create namespace bh-admission || true
-
-csrName=${title}.${title}
-tmpdir=$(mktemp -d)
-echo "creating certs in tmpdir ${tmpdir} "
-
-cat <<EOF >> ${tmpdir}/csr.conf
-[req]
-req_extensions = v3_req
-distinguished_name = req_distinguished_name
-[req_distinguished_name]
-[ v3_req ]
-basicConstraints = CA:FALSE
-keyUsage = nonRepudiation, digitalSignature, keyEncipherment
-extendedKeyUsage = serverAuth
-subjectAltName = @alt_names
-[alt_names]
-DNS.1 = ${title}
-DNS.2 = ${title}.${title}
-DNS.3 = ${title}.${title}.svc
-EOF
-
-openssl genrsa -out ${tmpdir}/server-key.pem 2048
-openssl req -new -key ${tmpdir}/server-key.pem -subj "/CN=${title}.${title}.svc" -out ${tmpdir}/server.csr -config ${tmpdir}/csr.conf
-
-# clean-up any previously created CSR for our service. Ignore errors if not present.
-${KUBECTL} delete csr ${csrName} 2>/dev/null || true
-
-# create  server cert/key CSR and  send to k8s API
-cat <<EOF | ${KUBECTL} create -f -
-apiVersion: certificates.k8s.io/v1beta1
-kind: CertificateSigningRequest
-metadata:
-  name: ${csrName}
-spec:
-  groups:
-  - system:authenticated
-  request: $(cat ${tmpdir}/server.csr | base64 | tr -d '\n')
-  usages:
-  - digital signature
-  - key encipherment
-  - server auth
-EOF
-
-# verify CSR has been created
-while true; do
-    ${KUBECTL} get csr ${csrName}
-    if [ "$?" -eq 0 ]; then
-        break
-    fi
-done
-
-# approve and fetch the signed certificate
-${KUBECTL} certificate approve ${csrName}
-# verify certificate has been signed
-for x in $(seq 10); do
-    serverCert=$(${KUBECTL} get csr ${csrName} -o jsonpath='{.status.certificate}')
-    if [[ ${serverCert} != '' ]]; then
-        break
-    fi
-    sleep 1
-done
-if [[ ${serverCert} == '' ]]; then
-    echo "ERROR: After approving csr ${csrName}, the signed certificate did not appear on the resource. Giving up after 10 attempts." >&2
-    exit 1
-fi
-echo ${serverCert} | openssl base64 -d -A -out ${tmpdir}/server-cert.pem
-
-
-# create the secret with CA cert and server cert/key
-${KUBECTL} create secret generic ${title}-certs \
-        --from-file=key.pem=${tmpdir}/server-key.pem \
-        --from-file=cert.pem=${tmpdir}/server-cert.pem \
-        --dry-run -o yaml |
-    ${KUBECTL} -n ${title} apply -f -
-
-rm -rf ${tmpdir}
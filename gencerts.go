@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/manifests"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ensureRetries is the number of attempts to get a certificate at startup, a concurrent
+// renewal by another replica fails the first attempt
+const ensureRetries = 3
+
+// newBootstrapper returns the certificate bootstrapper of the configuration, namespace is
+// used when cert_namespace is empty
+func newBootstrapper(cfg *config.Config, restConfig *restclient.Config, namespace string) (*certs.Bootstrapper, error) {
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	b := &certs.Bootstrapper{
+		Client:      client,
+		Mode:        cfg.CertMode,
+		Namespace:   cfg.CertNamespace,
+		Service:     cfg.CertService,
+		Secret:      cfg.CertSecret,
+		SignerName:  cfg.CertSignerName,
+		Approve:     cfg.CertApprove,
+		Validity:    cfg.CertValidity,
+		RenewBefore: cfg.CertRenewBefore,
+	}
+	if len(b.Namespace) == 0 {
+		b.Namespace = namespace
+	}
+	if b.Mode == certs.ModeCSR {
+		if b.Dynamic, err = dynamic.NewForConfig(restConfig); err != nil {
+			return nil, err
+		}
+		if len(cfg.CertCABundleFile) > 0 {
+			b.CABundle, err = ioutil.ReadFile(cfg.CertCABundleFile)
+		} else {
+			b.CABundle, err = manifests.ClusterCABundle(client)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// ensureCertificate gets a valid certificate from the bootstrapper and returns the time of the next renewal
+func ensureCertificate(b *certs.Bootstrapper) (time.Time, error) {
+	var renewAt time.Time
+	var err error
+	for attempt := 1; attempt <= ensureRetries; attempt++ {
+		if renewAt, err = b.Ensure(); err == nil {
+			return renewAt, nil
+		}
+		logrus.Warnln("Failed to get the serving certificate, attempt", attempt, ":", err)
+		if attempt < ensureRetries {
+			time.Sleep(time.Second)
+		}
+	}
+	return renewAt, err
+}
+
+// serveCertificates returns the serving certificate of the webhook and keeps it up to date,
+// from the certificate files or from the Secret managed by the bootstrapper.
+// The webhook configurations are registered with the caBundle when reg is not nil.
+func serveCertificates(cfg *config.Config, restConfig *restclient.Config, namespace, tlsCert, tlsKey string, reg *registration) (*certs.Keeper, error) {
+	keeper := &certs.Keeper{}
+	if cfg.CertMode == certs.ModeFile {
+		if err := keeper.LoadFiles(tlsCert, tlsKey); err != nil {
+			return nil, err
+		}
+		go config.Watch(func() []string { return []string{tlsCert, tlsKey} }, configPollInterval, make(chan struct{}), func() {
+			if err := keeper.LoadFiles(tlsCert, tlsKey); err != nil {
+				logrus.Errorln("Keeping the serving certificate, reload failed:", err)
+				return
+			}
+			logrus.Infoln("Serving certificate reloaded")
+		})
+		if reg != nil {
+			go reg.registerWithCABundleFile(cfg)
+		}
+		return keeper, nil
+	}
+	b, err := newBootstrapper(cfg, restConfig, namespace)
+	if err != nil {
+		return nil, err
+	}
+	b.Keeper = keeper
+	if reg != nil {
+		b.OnRotate = reg.registrar.SetCABundle
+	}
+	if _, err := ensureCertificate(b); err != nil {
+		return nil, err
+	}
+	go b.Run(make(chan struct{}))
+	return keeper, nil
+}
+
+// runGenCerts implements the gen-certs command and returns the exit code
+func runGenCerts(args []string) int {
+	flags := newFlagSet("gen-certs")
+	propertyFile := flags.String("config", "configmap.yaml", "bh-admission.properties or ConfigMap YAML")
+	namespace := flags.String("namespace", "bh-admission", "namespace of the webhook when cert_namespace is empty")
+	watch := flags.Bool("watch", false, "keep running and renew the certificate before it expires")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gen-certs [flags]")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	cfg, _, errs := loadConfigFile(*propertyFile, flags)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, *propertyFile+":", err)
+		}
+		return 1
+	}
+	if cfg.CertMode == certs.ModeFile {
+		// the webhook reads the files mounted from the Secret written here
+		cfg.CertMode = certs.ModeSelfSigned
+	}
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if err != nil {
+		logrus.Errorln("Invalid kubeconfig:", err)
+		return 1
+	}
+	b, err := newBootstrapper(cfg, restConfig, *namespace)
+	if err != nil {
+		logrus.Errorln("Failed to prepare the certificate:", err)
+		return 1
+	}
+	renewAt, err := ensureCertificate(b)
+	if err != nil {
+		logrus.Errorln("Failed to get the serving certificate:", err)
+		return 1
+	}
+	logrus.Infoln("Serving certificate in secret", b.Namespace+"/"+b.Secret, "is renewed after", renewAt)
+	if *watch {
+		b.Run(make(chan struct{}))
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/manifests"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// generatedHeader starts the YAML output of gen-manifests
+const generatedHeader = "# Generated by namespace-admission-controller gen-manifests, do not edit.\n"
+
+// listenPort returns the port of a listen address
+func listenPort(addr string) (int32, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return int32(n), err
+}
+
+// webhookOptions applies the configuration to the manifest options, the namespace of the
+// options is used when cert_namespace is empty
+func webhookOptions(cfg *config.Config, options manifests.Options) (manifests.Options, error) {
+	var err error
+	if options.Port, err = listenPort(cfg.ListenAddr); err != nil {
+		return options, errors.New("invalid listen_addr: " + err.Error())
+	}
+	if options.MetricsPort, err = listenPort(cfg.MetricsAddr); err != nil {
+		return options, errors.New("invalid metrics_addr: " + err.Error())
+	}
+	options.Name = cfg.CertService
+	if len(cfg.CertNamespace) > 0 {
+		options.Namespace = cfg.CertNamespace
+	}
+	options.WatchAPIServer = cfg.TLSProfileSource == config.TLSProfileSourceOpenShift
+	options.RecordEvents = cfg.Events.Enabled
+	options.CertMode = cfg.CertMode
+	options.CertSecret = cfg.CertSecret
+	if cfg.CertApprove {
+		options.ApproveSignerName = cfg.CertSignerName
+	}
+	options.ReadClusterCA = len(cfg.CertCABundleFile) == 0 &&
+		(cfg.CertMode == certs.ModeCSR || (cfg.CertMode == certs.ModeFile && cfg.WebhookRegistration))
+	options.Register = cfg.WebhookRegistration
+	options.TimeoutSeconds = cfg.WebhookTimeout
+	options.NamespaceSelector = cfg.NamespaceSelector
+	options.ObjectSelector = cfg.ObjectSelector
+	return options, nil
+}
+
+// renderManifests returns the manifests for the configuration in a property file or ConfigMap.
+// clusterCABundle, when not nil, provides a missing caBundle unless the webhook manages its certificate.
+func renderManifests(file string, flags *pflag.FlagSet, options manifests.Options, clusterCABundle func() ([]byte, error)) ([]runtime.Object, error) {
+	cfg, routes, errs := loadConfigFile(file, flags)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	if err := manifests.CheckOverlap(routes); err != nil {
+		return nil, err
+	}
+	options, err := webhookOptions(cfg, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.CABundle) == 0 && cfg.CertMode == certs.ModeFile && clusterCABundle != nil {
+		if options.CABundle, err = clusterCABundle(); err != nil {
+			return nil, errors.New("failed to read the CA bundle: " + err.Error())
+		}
+	}
+	return manifests.Render(options, routes), nil
+}
+
+// runGenManifests implements the gen-manifests command and returns the exit code
+func runGenManifests(args []string) int {
+	options := manifests.DefaultOptions()
+	flags := newFlagSet("gen-manifests")
+	propertyFile := flags.String("config", "configmap.yaml", "bh-admission.properties or ConfigMap YAML")
+	flags.StringVar(&options.Namespace, "namespace", options.Namespace, "namespace of the webhook when cert_namespace is empty")
+	flags.StringVar(&options.Image, "image", options.Image, "image of the webhook")
+	output := flags.String("output", "-", "output file, - for stdout")
+	caBundleFile := flags.String("ca-bundle-file", "", "CA bundle of the webhook configurations, read from kube-system/"+manifests.ExtensionAPIServerAuthentication+" with --apply when empty and cert_mode=file")
+	apply := flags.Bool("apply", false, "create or update the objects in the cluster of the kubeconfig")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gen-manifests [flags]")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var client kubernetes.Interface
+	if *apply {
+		restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(),
+			&clientcmd.ConfigOverrides{},
+		).ClientConfig()
+		if err != nil {
+			logrus.Errorln("Invalid kubeconfig:", err)
+			return 1
+		}
+		if client, err = kubernetes.NewForConfig(restConfig); err != nil {
+			logrus.Errorln("Invalid kubeconfig:", err)
+			return 1
+		}
+	}
+	var clusterCABundle func() ([]byte, error)
+	if len(*caBundleFile) > 0 {
+		var err error
+		if options.CABundle, err = ioutil.ReadFile(*caBundleFile); err != nil {
+			logrus.Errorln("Failed to read the CA bundle:", err)
+			return 1
+		}
+	} else if client != nil {
+		clusterCABundle = func() ([]byte, error) {
+			return manifests.ClusterCABundle(client)
+		}
+	}
+
+	objects, err := renderManifests(*propertyFile, flags, options, clusterCABundle)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, *propertyFile+":", err)
+		return 1
+	}
+	if client != nil {
+		if err := manifests.Apply(client, objects); err != nil {
+			logrus.Errorln("Failed to apply the manifests:", err)
+			return 1
+		}
+		return 0
+	}
+	content, err := manifests.YAML(objects)
+	if err != nil {
+		logrus.Errorln("Failed to render the manifests:", err)
+		return 1
+	}
+	content = append([]byte(generatedHeader), content...)
+	if *output == "-" {
+		os.Stdout.Write(content)
+	} else if err := ioutil.WriteFile(*output, content, 0644); err != nil {
+		logrus.Errorln("Failed to write the manifests:", err)
+		return 1
+	}
+	return 0
+}
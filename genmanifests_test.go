@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io/ioutil"
+	"namespace-admission-controller/manifests"
+	"testing"
+)
+
+func TestDeployYAMLIsGenerated(t *testing.T) {
+	objects, err := renderManifests("configmap.yaml", nil, manifests.DefaultOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := manifests.YAML(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deployed, err := ioutil.ReadFile("deploy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generatedHeader+string(content) != string(deployed) {
+		t.Error("deploy.yaml is out of date, run: go run . gen-manifests --config configmap.yaml --output deploy.yaml")
+	}
+}
@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/beorn7/perks v1.0.0
 	github.com/davecgh/go-spew v1.1.1
+	github.com/evanphx/json-patch v4.2.0+incompatible
 	github.com/go-delve/delve v1.5.0 // indirect
 	github.com/gogo/protobuf v1.2.2-0.20190723190241-65acae22fc9d
 	github.com/golang/protobuf v1.3.2
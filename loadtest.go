@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/server"
+	"namespace-admission-controller/webhook/webhooktest"
+	"net"
+	"net/http"
+	"os"
+	goruntime "runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// localWebhook serves routes over TLS on a loopback port with a certificate of a throwaway CA,
+// the way the API server reaches the webhook through its Service
+type localWebhook struct {
+	handler *server.ReloadableHandler
+	server  *http.Server
+	client  *http.Client
+	url     string
+}
+
+// startLocalWebhook serves the routes, the client keeps up to connections idle connections
+func startLocalWebhook(cfg *config.Config, routes []server.Route, connections int) (*localWebhook, error) {
+	ca, err := certs.NewCA("local", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	serving, err := ca.IssueServing(certs.DNSNames(cfg.CertService, "bh-admission"), time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	keeper := &certs.Keeper{}
+	if err := keeper.Set(serving.CertPEM, serving.KeyPEM); err != nil {
+		return nil, err
+	}
+	s := server.GetRouterServerNoSSL(routes, cfg.MaxRequestBytes, "127.0.0.1:0")
+	s.TLSConfig = &tls.Config{GetCertificate: keeper.GetCertificate}
+	handler := server.NewReloadableHandler(s.Handler)
+	s.Handler = handler
+	if _, err := server.ConfigureDynamicTLS(s, cfg.TLS); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() { _ = s.ServeTLS(listener, "", "") }()
+
+	// the API server connects to the Service with the caBundle of the webhook configuration
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{RootCAs: roots, ServerName: cfg.CertService + ".bh-admission.svc"},
+			MaxIdleConnsPerHost: connections,
+		},
+	}
+	return &localWebhook{
+		handler: handler,
+		server:  s,
+		client:  client,
+		url:     "https://" + listener.Addr().String(),
+	}, nil
+}
+
+func (w *localWebhook) stop() {
+	_ = w.server.Close()
+}
+
+// post sends the AdmissionReview body to the path and returns the response for the request uid
+func (w *localWebhook) post(path string, uid types.UID, body []byte) (*v1beta1.AdmissionResponse, error) {
+	r, err := w.client.Post(w.url+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d: %s", path, r.StatusCode, content)
+	}
+	response := &v1beta1.AdmissionReview{}
+	if err := json.Unmarshal(content, response); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if response.Response == nil || response.Response.UID != uid {
+		return nil, fmt.Errorf("%s: response without the UID of the request: %s", path, content)
+	}
+	return response.Response, nil
+}
+
+// loadTestOptions describe the load and the cluster seen by the requests
+type loadTestOptions struct {
+	// mix weighs the kinds of the reviews, every kind has the same weight when empty
+	mix         map[string]int
+	concurrency int
+	// the load test stops after duration or after requests reviews when requests is positive
+	duration time.Duration
+	requests int
+	// objects are the Namespaces, ServiceAccounts and Users that already exist
+	objects []runtime.Object
+	// externalLatency delays the responses of the stub external API
+	externalLatency  time.Duration
+	externalStatus   int
+	externalResponse string
+}
+
+// loadTestTarget is a review of the mix with the paths of the routes handling it
+type loadTestTarget struct {
+	uid   types.UID
+	body  []byte
+	paths []string
+}
+
+// loadTestReport summarizes a load test, the latency of a review includes all of its routes
+type loadTestReport struct {
+	Reviews       int
+	Errors        int
+	Denied        int
+	FirstError    string
+	Duration      time.Duration
+	P50           time.Duration
+	P99           time.Duration
+	Max           time.Duration
+	ExternalCalls int
+	// Mallocs and AllocBytes are allocated by the server and the client of the load test
+	Mallocs    uint64
+	AllocBytes uint64
+}
+
+// reviewKind is the key of the review in the mix
+func reviewKind(review *v1beta1.AdmissionReview) string {
+	return strings.ToLower(review.Request.Kind.Kind)
+}
+
+// parseMix parses a comma separated list of kind=weight entries, for example "project=8,serviceaccount=2"
+func parseMix(spec string) (map[string]int, error) {
+	mix := map[string]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		kindAndWeight := strings.SplitN(entry, "=", 2)
+		if len(kindAndWeight) != 2 {
+			return nil, errors.New("mix entry " + entry + " must be in the form kind=weight")
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(kindAndWeight[1]))
+		if err != nil || weight < 0 {
+			return nil, errors.New("mix entry " + entry + " must have a weight of 0 or more")
+		}
+		mix[strings.ToLower(strings.TrimSpace(kindAndWeight[0]))] = weight
+	}
+	return mix, nil
+}
+
+// matrixReviews are the creations of new objects of the test matrix, the default reviews of the load test
+func matrixReviews() []*v1beta1.AdmissionReview {
+	reviews := []*v1beta1.AdmissionReview{}
+	for _, c := range webhooktest.Matrix() {
+		if c.Existing == nil {
+			reviews = append(reviews, c.Review)
+		}
+	}
+	return reviews
+}
+
+// loadTestTargets groups the reviews handled by the routes by kind
+func loadTestTargets(routes []server.Route, reviews []*v1beta1.AdmissionReview) (map[string][]loadTestTarget, error) {
+	targets := map[string][]loadTestTarget{}
+	for _, review := range reviews {
+		target := loadTestTarget{uid: review.Request.UID}
+		for _, route := range routes {
+			for _, plugin := range route.Chain.Plugins {
+				if server.Handles(plugin, review.Request) {
+					target.paths = append(target.paths, route.Path)
+					break
+				}
+			}
+		}
+		if len(target.paths) == 0 {
+			continue
+		}
+		body, err := json.Marshal(review)
+		if err != nil {
+			return nil, err
+		}
+		target.body = body
+		targets[reviewKind(review)] = append(targets[reviewKind(review)], target)
+	}
+	return targets, nil
+}
+
+// loadTestPicker chooses the kind by its weight in the mix, then a review of the kind
+type loadTestPicker struct {
+	kinds   [][]loadTestTarget
+	weights []int
+	total   int
+}
+
+func newLoadTestPicker(targets map[string][]loadTestTarget, mix map[string]int) (*loadTestPicker, error) {
+	available := []string{}
+	for kind := range targets {
+		available = append(available, kind)
+	}
+	sort.Strings(available)
+	if len(mix) == 0 {
+		mix = map[string]int{}
+		for _, kind := range available {
+			mix[kind] = 1
+		}
+	}
+	picker := &loadTestPicker{}
+	kinds := []string{}
+	for kind := range mix {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if mix[kind] == 0 {
+			continue
+		}
+		if len(targets[kind]) == 0 {
+			return nil, errors.New("no reviews of kind " + kind + " handled by the routes, available kinds: " + strings.Join(available, ", "))
+		}
+		picker.kinds = append(picker.kinds, targets[kind])
+		picker.weights = append(picker.weights, mix[kind])
+		picker.total += mix[kind]
+	}
+	if picker.total == 0 {
+		return nil, errors.New("no reviews in the mix")
+	}
+	return picker, nil
+}
+
+func (picker *loadTestPicker) pick(random *rand.Rand) loadTestTarget {
+	n := random.Intn(picker.total)
+	for i, weight := range picker.weights {
+		if n < weight {
+			return picker.kinds[i][random.Intn(len(picker.kinds[i]))]
+		}
+		n -= weight
+	}
+	return picker.kinds[0][0]
+}
+
+// percentile returns the p-th percentile of the sorted latencies, from 0 to 1
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// loadTest posts the reviews of the mix to a local server running the routes of the configuration
+// with fake clients and a stub external API, from concurrency workers
+func loadTest(cfg *config.Config, reviews []*v1beta1.AdmissionReview, options loadTestOptions) (*loadTestReport, error) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	externalAPI.DiscardCalls()
+	externalAPI.Respond(options.externalStatus, options.externalResponse)
+	externalAPI.SetDelay(options.externalLatency)
+	// the external API is only called when it is configured
+	loadTestConfig := *cfg
+	if len(loadTestConfig.ExternalAPIURL) > 0 {
+		loadTestConfig.ExternalAPIURL = externalAPI.URL()
+	}
+	routes, err := replayRoutes(&loadTestConfig, options.objects)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := loadTestTargets(routes, reviews)
+	if err != nil {
+		return nil, err
+	}
+	picker, err := newLoadTestPicker(targets, options.mix)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := options.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	w, err := startLocalWebhook(&loadTestConfig, routes, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer w.stop()
+
+	var before, after goruntime.MemStats
+	goruntime.ReadMemStats(&before)
+	var started int64
+	deadline := time.Now().Add(options.duration)
+	latencies := make([][]time.Duration, concurrency)
+	errorCounts := make([]int, concurrency)
+	deniedCounts := make([]int, concurrency)
+	var firstError atomic.Value
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			for {
+				if options.requests > 0 {
+					if atomic.AddInt64(&started, 1) > int64(options.requests) {
+						return
+					}
+				} else if time.Now().After(deadline) {
+					return
+				}
+				target := picker.pick(random)
+				requestStart := time.Now()
+				denied := false
+				var err error
+				for _, path := range target.paths {
+					var response *v1beta1.AdmissionResponse
+					if response, err = w.post(path, target.uid, target.body); err != nil {
+						break
+					}
+					if !response.Allowed {
+						denied = true
+						break
+					}
+				}
+				latencies[worker] = append(latencies[worker], time.Since(requestStart))
+				if err != nil {
+					errorCounts[worker]++
+					firstError.Store(err.Error())
+				} else if denied {
+					deniedCounts[worker]++
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	report := &loadTestReport{Duration: time.Since(start), ExternalCalls: externalAPI.Count()}
+	goruntime.ReadMemStats(&after)
+	report.Mallocs = after.Mallocs - before.Mallocs
+	report.AllocBytes = after.TotalAlloc - before.TotalAlloc
+
+	all := []time.Duration{}
+	for worker := 0; worker < concurrency; worker++ {
+		all = append(all, latencies[worker]...)
+		report.Errors += errorCounts[worker]
+		report.Denied += deniedCounts[worker]
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	report.Reviews = len(all)
+	report.P50 = percentile(all, 0.50)
+	report.P99 = percentile(all, 0.99)
+	report.Max = percentile(all, 1)
+	if e, ok := firstError.Load().(string); ok {
+		report.FirstError = e
+	}
+	return report, nil
+}
+
+// String formats the report for the terminal
+func (report *loadTestReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "reviews         %d (%d errors, %d denied)\n", report.Reviews, report.Errors, report.Denied)
+	fmt.Fprintf(&b, "duration        %s\n", report.Duration.Round(time.Millisecond))
+	if report.Duration > 0 {
+		fmt.Fprintf(&b, "throughput      %.1f reviews/s\n", float64(report.Reviews)/report.Duration.Seconds())
+	}
+	fmt.Fprintf(&b, "latency p50     %s\n", report.P50.Round(time.Microsecond))
+	fmt.Fprintf(&b, "latency p99     %s\n", report.P99.Round(time.Microsecond))
+	fmt.Fprintf(&b, "latency max     %s\n", report.Max.Round(time.Microsecond))
+	fmt.Fprintf(&b, "external calls  %d\n", report.ExternalCalls)
+	if report.Reviews > 0 {
+		fmt.Fprintf(&b, "allocations     %d per review, %d bytes per review (server and client)\n",
+			report.Mallocs/uint64(report.Reviews), report.AllocBytes/uint64(report.Reviews))
+	}
+	if len(report.FirstError) > 0 {
+		fmt.Fprintf(&b, "first error     %s\n", report.FirstError)
+	}
+	return b.String()
+}
+
+// writeProfile writes the named runtime profile to the file
+func writeProfile(name string, file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pprof.Lookup(name).WriteTo(f, 0)
+}
+
+// runLoadTest implements the loadtest command and returns the exit code
+func runLoadTest(args []string) int {
+	flags := newFlagSet("loadtest")
+	propertyFile := flags.String("config", "configmap.yaml", "bh-admission.properties or ConfigMap YAML")
+	mixSpec := flags.String("mix", "", "kind=weight list of the reviews, for example project=8,serviceaccount=2, all kinds equally when empty")
+	objectsFile := flags.String("objects", "", "YAML file with the Namespaces, ServiceAccounts and Users that already exist")
+	concurrency := flags.Int("concurrency", 8, "number of concurrent clients")
+	duration := flags.Duration("duration", 10*time.Second, "duration of the load test")
+	requests := flags.Int("requests", 0, "number of reviews sent, instead of --duration when positive")
+	externalLatency := flags.Duration("external-latency", 50*time.Millisecond, "latency of the stub external API")
+	externalStatus := flags.Int("external-status", http.StatusOK, "HTTP status returned by the stub external API")
+	externalResponse := flags.String("external-response", `{"allowed": true}`, "body returned by the stub external API")
+	cpuProfile := flags.String("cpu-profile", "", "write a CPU profile of the load test to this file")
+	allocProfile := flags.String("alloc-profile", "", "write the allocation profile to this file after the load test")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: loadtest [flags] [AdmissionReview JSONL file, the test matrix when omitted]")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	args = flags.Args()
+	if len(args) > 1 {
+		flags.Usage()
+		return 2
+	}
+	mix, err := parseMix(*mixSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--mix:", err)
+		return 2
+	}
+	cfg, _, errs := loadConfigFile(*propertyFile, flags)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, *propertyFile+":", err)
+		}
+		return 1
+	}
+	if err := configureLogging(cfg); err != nil {
+		logrus.Errorln("Invalid logging configuration:", err)
+		return 1
+	}
+	options := loadTestOptions{
+		mix:              mix,
+		concurrency:      *concurrency,
+		duration:         *duration,
+		requests:         *requests,
+		externalLatency:  *externalLatency,
+		externalStatus:   *externalStatus,
+		externalResponse: *externalResponse,
+	}
+	if len(*objectsFile) > 0 {
+		if options.objects, err = loadReplayObjects(*objectsFile); err != nil {
+			fmt.Fprintln(os.Stderr, *objectsFile+":", err)
+			return 1
+		}
+	}
+	reviews := matrixReviews()
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		reviews = nil
+		err = scanReviews(f, func(line int, review *v1beta1.AdmissionReview) error {
+			reviews = append(reviews, review)
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, args[0]+":", err)
+			return 1
+		}
+	}
+
+	if len(*cpuProfile) > 0 {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	report, err := loadTest(cfg, reviews, options)
+	if len(*cpuProfile) > 0 {
+		pprof.StopCPUProfile()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(*allocProfile) > 0 {
+		if err := writeProfile("allocs", *allocProfile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	fmt.Print(report.String())
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
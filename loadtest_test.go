@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix(" Project=8, serviceaccount=2,,user=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int{"project": 8, "serviceaccount": 2, "user": 0}; !reflect.DeepEqual(mix, expected) {
+		t.Errorf("expected %v, got %v", expected, mix)
+	}
+	for _, spec := range []string{"project", "project=a", "project=-1"} {
+		if _, err := parseMix(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestLoadTest(t *testing.T) {
+	cfg, _, errs := loadConfigFile("configmap.yaml", nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	options := loadTestOptions{
+		mix:              map[string]int{"project": 3, "serviceaccount": 1},
+		concurrency:      2,
+		requests:         40,
+		externalStatus:   http.StatusOK,
+		externalResponse: `{"allowed": true}`,
+	}
+	report, err := loadTest(cfg, matrixReviews(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Reviews != 40 || report.Errors > 0 || report.Denied > 0 {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+	// only the service accounts are registered with the external API in notify mode
+	if report.ExternalCalls == 0 || report.ExternalCalls == report.Reviews {
+		t.Errorf("expected external calls for the service accounts only:\n%s", report)
+	}
+	if report.P50 <= 0 || report.P99 < report.P50 || report.Max < report.P99 {
+		t.Errorf("inconsistent latencies:\n%s", report)
+	}
+
+	options.mix = map[string]int{"deployment": 1}
+	if _, err := loadTest(cfg, matrixReviews(), options); err == nil || !strings.Contains(err.Error(), "available kinds: namespace, project, serviceaccount, user") {
+		t.Error("expected an error for a kind without reviews, got", err)
+	}
+}
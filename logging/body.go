@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+// Redacted replaces the values of redacted fields
+const Redacted = "REDACTED"
+
+// DefaultRedactPaths hide the user's extra attributes and the content of secrets
+var DefaultRedactPaths = []string{
+	"request.userInfo.extra",
+	"request.object.data",
+	"request.object.stringData",
+	"request.oldObject.data",
+	"request.oldObject.stringData",
+}
+
+// BodyOptions configures logging of full AdmissionReviews
+type BodyOptions struct {
+	Enabled bool
+	// SampleRate is the fraction of reviews logged, from 0 to 1
+	SampleRate float64
+	// RedactPaths are dotted JSON field paths, * matches any field or list item
+	RedactPaths []string
+}
+
+var bodyOptions atomic.Value
+
+func init() {
+	bodyOptions.Store(BodyOptions{})
+}
+
+// ConfigureBody replaces the options for logging full AdmissionReviews
+func ConfigureBody(options BodyOptions) {
+	bodyOptions.Store(options)
+}
+
+// LogReview logs the redacted review at debug level when body logging is enabled and the review is sampled
+func LogReview(log *logrus.Entry, review *v1beta1.AdmissionReview) {
+	options := bodyOptions.Load().(BodyOptions)
+	if !options.Enabled || !log.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	if options.SampleRate < 1 && rand.Float64() >= options.SampleRate {
+		return
+	}
+	body, err := RedactedReview(review, options.RedactPaths, Redact)
+	if err != nil {
+		log.Errorln("Failed to redact review:", err)
+		return
+	}
+	log.WithField("review", body).Debug("AdmissionReview")
+}
+
+// RedactedReview returns the decoded JSON of the review with the values at the dotted paths redacted by redact
+func RedactedReview(review *v1beta1.AdmissionReview, redactPaths []string, redact func(interface{}, []string)) (interface{}, error) {
+	b, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+	var body interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+	for _, path := range redactPaths {
+		if path = strings.TrimSpace(path); len(path) > 0 {
+			redact(body, strings.Split(path, "."))
+		}
+	}
+	return body, nil
+}
+
+// Redact replaces the values at the path within the decoded JSON
+func Redact(value interface{}, path []string) {
+	redactPath(value, path, func(interface{}) interface{} { return Redacted })
+}
+
+// RedactLeaves replaces the strings within the values at the path and keeps their structure,
+// the redacted JSON still decodes into the types of the original
+func RedactLeaves(value interface{}, path []string) {
+	redactPath(value, path, redactLeaves)
+}
+
+func redactLeaves(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return Redacted
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactLeaves(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactLeaves(child)
+		}
+	}
+	return value
+}
+
+// redactPath replaces the values at the path with the result of redact
+func redactPath(value interface{}, path []string, redact func(interface{}) interface{}) {
+	if len(path) == 0 {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if key != path[0] && path[0] != "*" {
+				continue
+			}
+			if len(path) == 1 {
+				v[key] = redact(child)
+			} else {
+				redactPath(child, path[1:], redact)
+			}
+		}
+	case []interface{}:
+		if path[0] != "*" {
+			return
+		}
+		for i, child := range v {
+			if len(path) == 1 {
+				v[i] = redact(child)
+			} else {
+				redactPath(child, path[1:], redact)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected string
+	}{
+		{"field", "request.userInfo.extra", `{"request":{"userInfo":{"username":"a","extra":{"scopes":["x"]}}}}`, `{"request":{"userInfo":{"username":"a","extra":"REDACTED"}}}`},
+		{"missing field", "request.object.data", `{"request":{"object":{"kind":"Namespace"}}}`, `{"request":{"object":{"kind":"Namespace"}}}`},
+		{"wildcard", "spec.containers.*.env", `{"spec":{"containers":[{"name":"a","env":[]},{"name":"b"}]}}`, `{"spec":{"containers":[{"name":"a","env":"REDACTED"},{"name":"b"}]}}`},
+		{"wildcard field", "data.*", `{"data":{"a":"1","b":"2"}}`, `{"data":{"a":"REDACTED","b":"REDACTED"}}`},
+	}
+	for _, test := range tests {
+		var body, expected interface{}
+		_ = json.Unmarshal([]byte(test.body), &body)
+		_ = json.Unmarshal([]byte(test.expected), &expected)
+		Redact(body, strings.Split(test.path, "."))
+		if !reflect.DeepEqual(body, expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, body)
+		}
+	}
+}
+
+func TestRedactLeaves(t *testing.T) {
+	var body, expected interface{}
+	_ = json.Unmarshal([]byte(`{"extra":{"scopes":["a","b"]},"data":{"key":"secret","size":3}}`), &body)
+	_ = json.Unmarshal([]byte(`{"extra":{"scopes":["REDACTED","REDACTED"]},"data":{"key":"REDACTED","size":3}}`), &expected)
+	RedactLeaves(body, []string{"extra"})
+	RedactLeaves(body, []string{"data"})
+	if !reflect.DeepEqual(body, expected) {
+		t.Errorf("expected %v, got %v", expected, body)
+	}
+}
+
+// BenchmarkLogReview measures the cost of body logging per request, nothing is marshalled when it is disabled
+func BenchmarkLogReview(b *testing.B) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Formatter = &logrus.JSONFormatter{}
+	logger.Level = logrus.DebugLevel
+	review := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+		UID:      "e911857d-c318-11e8-bbad-025000000001",
+		Kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+		UserInfo: authenticationv1.UserInfo{Username: "alice", Extra: map[string]authenticationv1.ExtraValue{"scopes.authorization.openshift.io": {"user:full"}}},
+		Object:   runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"test","labels":{"app":"test"}}}`)},
+	}}
+	defer ConfigureBody(BodyOptions{})
+	for _, enabled := range []bool{false, true} {
+		ConfigureBody(BodyOptions{Enabled: enabled, SampleRate: 1, RedactPaths: DefaultRedactPaths})
+		b.Run("enabled="+strconv.FormatBool(enabled), func(b *testing.B) {
+			log := logrus.NewEntry(logger)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				LogReview(log, review)
+			}
+		})
+	}
+}
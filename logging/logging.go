@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+const (
+	// FormatJSON writes one JSON object per line
+	FormatJSON = "json"
+	// FormatText writes the logrus text format
+	FormatText = "text"
+
+	// LevelSourceConfig is the source of the log level set by the configuration
+	LevelSourceConfig = "config"
+	// LevelSourceOverride is the source of a log level set at runtime by the admin endpoint or a signal,
+	// it is kept across configuration reloads until it is cleared
+	LevelSourceOverride = "override"
+)
+
+var (
+	levelMutex sync.Mutex
+	// configuredLevel is the level of the configuration, applied when there is no override
+	configuredLevel = logrus.InfoLevel
+	overridden      bool
+)
+
+// levels from the least to the most verbose, changed one step at a time by the signals
+var levels = []logrus.Level{
+	logrus.PanicLevel,
+	logrus.FatalLevel,
+	logrus.ErrorLevel,
+	logrus.WarnLevel,
+	logrus.InfoLevel,
+	logrus.DebugLevel,
+	logrus.TraceLevel,
+}
+
+// Configure sets the log format and level of the standard logger. The level is not changed while
+// it is overridden.
+func Configure(format string, level string) error {
+	if err := Check(format, level); err != nil {
+		return err
+	}
+	if format == FormatText {
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	}
+	l, _ := logrus.ParseLevel(level)
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	configuredLevel = l
+	if overridden {
+		if logrus.GetLevel() != l {
+			logrus.WithField("level", logrus.GetLevel().String()).Infoln("Keeping the overridden log level instead of", l.String())
+		}
+		return nil
+	}
+	logrus.SetLevel(l)
+	return nil
+}
+
+// Check returns the error Configure would return without changing the logger
+func Check(format string, level string) error {
+	if format != FormatJSON && format != FormatText {
+		return errors.New("unknown log format " + format)
+	}
+	_, err := logrus.ParseLevel(level)
+	return err
+}
+
+// SetLevel overrides the log level of the standard logger until ClearLevel is called
+func SetLevel(level string) error {
+	l, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	overridden = true
+	logrus.SetLevel(l)
+	return nil
+}
+
+// ClearLevel removes the override and restores the log level of the configuration
+func ClearLevel() logrus.Level {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	overridden = false
+	logrus.SetLevel(configuredLevel)
+	return configuredLevel
+}
+
+// LevelSource returns LevelSourceOverride when the log level is overridden, LevelSourceConfig otherwise
+func LevelSource() string {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	if overridden {
+		return LevelSourceOverride
+	}
+	return LevelSourceConfig
+}
+
+// stepLevel overrides the log level, more verbose for a positive step and less verbose for a negative step
+func stepLevel(step int) logrus.Level {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	current := logrus.GetLevel()
+	for i, l := range levels {
+		if l == current && i+step >= 0 && i+step < len(levels) {
+			current = levels[i+step]
+			break
+		}
+	}
+	overridden = true
+	logrus.SetLevel(current)
+	return current
+}
+
+// HandleSignals makes the log level more verbose on SIGUSR1 and less verbose on SIGUSR2
+func HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for s := range signals {
+			step := 1
+			if s == syscall.SIGUSR2 {
+				step = -1
+			}
+			level := stepLevel(step)
+			logrus.WithField("level", level.String()).Warn("Log level changed by signal")
+		}
+	}()
+}
+
+// RequestFields returns the fields that correlate log lines with an admission request
+func RequestFields(request *v1beta1.AdmissionRequest) logrus.Fields {
+	return logrus.Fields{
+		"uid":       request.UID,
+		"operation": request.Operation,
+		"kind":      request.Kind.Kind,
+		"namespace": request.Namespace,
+		"name":      request.Name,
+		"user":      request.UserInfo.Username,
+	}
+}
+
+// ForRequest returns a logger for the lines about an admission request
+func ForRequest(request *v1beta1.AdmissionRequest) *logrus.Entry {
+	return logrus.WithFields(RequestFields(request))
+}
@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+func TestStepLevel(t *testing.T) {
+	if err := SetLevel("info"); err != nil {
+		t.Fatal(err)
+	}
+	if level := stepLevel(1); level.String() != "debug" {
+		t.Error("Expected debug, got", level)
+	}
+	if level := stepLevel(-2); level.String() != "warning" {
+		t.Error("Expected warning, got", level)
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+	ClearLevel()
+}
+
+func TestOverriddenLevel(t *testing.T) {
+	defer ClearLevel()
+	if err := Configure(FormatJSON, "info"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	// a reload keeps the override
+	if err := Configure(FormatJSON, "warn"); err != nil {
+		t.Fatal(err)
+	}
+	if logrus.GetLevel() != logrus.DebugLevel || LevelSource() != LevelSourceOverride {
+		t.Errorf("Expected the overridden debug level, got %s from %s", logrus.GetLevel(), LevelSource())
+	}
+	if level := ClearLevel(); level != logrus.WarnLevel || logrus.GetLevel() != logrus.WarnLevel || LevelSource() != LevelSourceConfig {
+		t.Errorf("Expected the configured warning level, got %s from %s", logrus.GetLevel(), LevelSource())
+	}
+}
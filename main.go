@@ -1,36 +1,43 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
+	"github.com/spf13/pflag"
+	"namespace-admission-controller/admin"
+	"namespace-admission-controller/capture"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/logging"
 	"namespace-admission-controller/server"
+	"namespace-admission-controller/tlsprofile"
 	"namespace-admission-controller/webhook"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	configv1client "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
 	//buildv1client "github.com/openshift/client-go/build/clientset/versioned/typed/build/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
-	propertyFile = "/etc/webhook/bh-admission-config/bh-admission.properties"
 	// TLSCert is the TLS certificate
 	TLSCert = "/etc/webhook/certs/cert.pem"
 	// TLSKey is the TLS key
-	TLSKey                  = "/etc/webhook/certs/key.pem"
-	listenAddrKey           = "listen_addr"
-	listenAddrDefaultValue  = "0.0.0.0:8080"
-	metricsAddrKey          = "metrics_addr"
-	metricsAddrDefaultValue = ":2112"
-	externalAPIURLKey       = "external_api_url"
-	externalAPITimeoutKey   = "external_api_timeout"
-	requesterKey            = "requester_key"
-	clusterNameKey          = "cluster_name_key"
+	TLSKey = "/etc/webhook/certs/key.pem"
+	// configPollInterval is the interval for checking the property and resource rules files for changes
+	configPollInterval = 10 * time.Second
+	// shutdownTimeout is the time for completing the requests in progress on SIGTERM
+	shutdownTimeout = 10 * time.Second
 )
 
 func getClustername(urlString string) (string, error) {
@@ -49,25 +56,128 @@ func getClustername(urlString string) (string, error) {
 	return clusterName, err
 }
 
-func main() {
-	// set up defaults
-	viper.SetDefault(listenAddrKey, listenAddrDefaultValue)
-	viper.SetDefault(metricsAddrKey, metricsAddrDefaultValue)
-	viper.SetDefault(externalAPITimeoutKey, 12)
-	viper.SetDefault(requesterKey, "company.com/requester")
-	viper.AutomaticEnv()
-
-	// override defaults with property file values
-	viper.SetConfigFile(propertyFile)
-	err := viper.ReadInConfig()
+// clients are shared by every configuration of the webhook
+type clients struct {
+	webhook     webhook.Clients
+	clusterName string
+	// events sends the events of the plugins to the API server, no events are recorded when nil
+	events record.EventBroadcaster
+}
+
+// newRoutes builds the plugins of the configuration and binds them to the configured routes
+func (c *clients) newRoutes(cfg *config.Config) ([]server.Route, error) {
+	clusterName := cfg.ClusterName
+	if len(clusterName) == 0 {
+		clusterName = c.clusterName
+	}
+	nsac := webhook.BhAdmission{
+		ExternalAPIURL:           cfg.ExternalAPIURL,
+		ExternalAPITimeout:       cfg.ExternalAPITimeout,
+		ExternalAPIMode:          cfg.ExternalAPIMode,
+		ExternalAPIFailurePolicy: cfg.ExternalAPIFailurePolicy,
+		RequesterKey:             cfg.RequesterKey,
+		Labels:                   cfg.Labels,
+		LabelValueMode:           cfg.LabelValueMode,
+		ResourceRules:            cfg.ResourceRules,
+		NamingPolicy:             cfg.NamingPolicy,
+		MaxNamespacesPerOwner:    cfg.MaxNamespacesPerOwner,
+		QuotaFailurePolicy:       cfg.QuotaFailurePolicy,
+		Clients:                  c.webhook,
+		ClusterName:              clusterName,
+		Recorder:                 webhook.NewEventRecorder(c.events, cfg.Events),
+	}
+	registry := server.NewPluginRegistry()
+	registry.Metrics = nsac.RequestMetrics
+	for _, plugin := range nsac.Plugins() {
+		if err := registry.Register(plugin); err != nil {
+			return nil, err
+		}
+	}
+	routes, err := registry.Routes(cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		logrus.Println("route", route.Path, route.Type, "plugins:", strings.Join(routePlugins(route), ","))
+	}
+	return routes, nil
+}
+
+func routePlugins(route server.Route) []string {
+	names := []string{}
+	for _, plugin := range route.Chain.Plugins {
+		names = append(names, plugin.Name())
+	}
+	return names
+}
+
+// configureLogging applies the logging and capture settings
+func configureLogging(cfg *config.Config) error {
+	update, err := prepareLogging(cfg)
+	if err != nil {
+		return err
+	}
+	update.apply()
+	return nil
+}
+
+// loggingUpdate holds logging and capture settings that were checked but not applied yet
+type loggingUpdate struct {
+	cfg     *config.Config
+	capture *capture.Pending
+}
+
+// prepareLogging checks the logging settings and opens the capture output without changing the running
+// ones, so that a reload either applies both or none of them
+func prepareLogging(cfg *config.Config) (*loggingUpdate, error) {
+	if err := logging.Check(cfg.LogFormat, cfg.LogLevel); err != nil {
+		return nil, err
+	}
+	pending, err := capture.Prepare(cfg.Capture)
 	if err != nil {
-		logrus.Infoln("Config file "+propertyFile+":", err)
+		return nil, err
 	}
-	logrus.Println(viper.AllSettings())
+	return &loggingUpdate{cfg: cfg, capture: pending}, nil
+}
 
-	if viper.GetBool("DEBUG") {
-		logrus.SetLevel(logrus.DebugLevel)
+// apply can't fail, the settings were checked by prepareLogging
+func (update *loggingUpdate) apply() {
+	_ = logging.Configure(update.cfg.LogFormat, update.cfg.LogLevel)
+	logging.ConfigureBody(update.cfg.LogBody)
+	update.capture.Apply()
+}
+
+// discard closes the capture output opened by prepareLogging
+func (update *loggingUpdate) discard() {
+	update.capture.Discard()
+}
+
+// warnConfig logs the settings that were accepted and adjusted
+func warnConfig(cfg *config.Config) {
+	for _, warning := range cfg.Warnings {
+		logrus.Warnln("Configuration adjusted:", warning)
 	}
+}
+
+// serve runs the webhook until it fails
+func serve(propertyFile string, tlsCert string, tlsKey string, flags *pflag.FlagSet) {
+	settings, configErr := config.Read(propertyFile, flags)
+	cfg, err := config.Load(settings)
+	if err != nil {
+		logrus.Errorln("Invalid configuration:", err)
+		os.Exit(1)
+	}
+	if err := configureLogging(cfg); err != nil {
+		logrus.Errorln("Invalid logging configuration:", err)
+		os.Exit(1)
+	}
+	logging.HandleSignals()
+	warnConfig(cfg)
+	if configErr != nil {
+		logrus.Infoln("Config file "+propertyFile+":", configErr)
+	}
+	logrus.Println(admin.MaskSecrets(settings.AllSettings()))
+	logrus.Println("resource rules:", len(cfg.ResourceRules))
 
 	// Instantiate loader for kubeconfig file.
 	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
@@ -83,50 +193,141 @@ func main() {
 	}
 	logrus.Println("namespace=", namespace)
 
-	clusterName := viper.GetString(clusterNameKey)
-	if len(clusterName) == 0 {
+	// Get a rest.Config from the kubeconfig file.  This will be passed into all
+	// the client objects we create.
+	restconfig, err := kubeconfig.ClientConfig()
+	if err != nil {
+		panic(err)
+	}
+	c := &clients{}
+	if len(cfg.ClusterName) == 0 {
 		// clientConfig.HOST gave IP address
-		clientConfig, err := kubeconfig.ClientConfig()
-		if err != nil {
-			panic(err)
-		}
-		clusterName, _ = getClustername(clientConfig.Host)
-		logrus.Println("clusterName=", clusterName)
+		c.clusterName, _ = getClustername(restconfig.Host)
+		logrus.Println("clusterName=", c.clusterName)
 		rawConfig, _ := kubeconfig.RawConfig()
 		logrus.Println("rawConfig.CurrentContext =", rawConfig.CurrentContext)
 	}
-	// Get a rest.Config from the kubeconfig file.  This will be passed into all
-	// the client objects we create.
-	restconfig, err := kubeconfig.ClientConfig()
+	c.webhook, err = webhook.NewClients(restconfig)
 	if err != nil {
 		panic(err)
 	}
+	c.events = webhook.NewEventBroadcaster(c.webhook.Core)
 
 	go func() {
-		metricsAddr := viper.GetString(metricsAddrKey)
 		// blocking method needs to run in a separate thread
-		logrus.Println("metrics starting to listen on ", metricsAddr)
+		logrus.Println("metrics starting to listen on ", cfg.MetricsAddr)
 		http.Handle("/metrics", promhttp.Handler())
-		err = http.ListenAndServe(metricsAddr, nil)
+		err := http.ListenAndServe(cfg.MetricsAddr, nil)
 		if err != nil {
 			logrus.Errorln("Failed to start metrics listener:", err)
 			os.Exit(1)
 		}
 	}()
 
-	listenAddr := viper.GetString(listenAddrKey)
-	nsac := webhook.BhAdmission{
-		ExternalAPIURL:     viper.GetString(externalAPIURLKey),
-		ExternalAPITimeout: viper.GetInt32(externalAPITimeoutKey),
-		RequesterKey:       viper.GetString(requesterKey),
-		RestConfig:         *restconfig,
-		ClusterName:        clusterName,
-	}
-	s := server.GetAdmissionValidationServer(&nsac, TLSCert, TLSKey, listenAddr)
-	logrus.Println("Webhook starting to listen on ", listenAddr)
-	err = s.ListenAndServeTLS("", "")
+	routes, err := c.newRoutes(cfg)
+	if err != nil {
+		logrus.Errorln("Invalid routes:", err)
+		os.Exit(1)
+	}
+	var reg *registration
+	if cfg.WebhookRegistration {
+		if reg, err = newRegistration(cfg, restconfig, namespace, routes); err != nil {
+			logrus.Errorln("Invalid webhook registration:", err)
+			os.Exit(1)
+		}
+	}
+	keeper, err := serveCertificates(cfg, restconfig, namespace, tlsCert, tlsKey, reg)
+	if err != nil {
+		logrus.Errorln("No serving certificate:", err)
+		os.Exit(1)
+	}
+	s := server.GetRouterServerNoSSL(routes, cfg.MaxRequestBytes, cfg.ListenAddr)
+	s.TLSConfig = &tls.Config{GetCertificate: keeper.GetCertificate}
+	handler := server.NewReloadableHandler(s.Handler)
+	s.Handler = handler
+
+	tlsOptions := cfg.TLS
+	var profileWatcher *tlsprofile.Watcher
+	if cfg.TLSProfileSource == config.TLSProfileSourceOpenShift {
+		configClient, err := configv1client.NewForConfig(restconfig)
+		if err != nil {
+			panic(err)
+		}
+		profileWatcher = &tlsprofile.Watcher{
+			Client: configClient,
+			HTTP2:  tlsOptions.HTTP2,
+		}
+		if options, err := profileWatcher.Current(); err != nil {
+			logrus.Warnln("Using static TLS configuration until the APIServer TLS security profile is available:", err)
+		} else {
+			tlsOptions = options
+		}
+	}
+	tlsReloader, err := server.ConfigureDynamicTLS(s, tlsOptions)
 	if err != nil {
+		logrus.Errorln("Invalid TLS configuration:", err)
+		os.Exit(1)
+	}
+	if profileWatcher != nil {
+		profileWatcher.Reloader = tlsReloader
+		go profileWatcher.Run(make(chan struct{}))
+	}
+	if len(cfg.ClientCAFile) > 0 {
+		if err := server.RequireClientCertificates(s, cfg.ClientCAFile, cfg.AllowedClientNames); err != nil {
+			logrus.Errorln("Invalid client_ca_file:", err)
+			os.Exit(1)
+		}
+		logrus.Println("client certificates required, allowed names:", strings.Join(cfg.AllowedClientNames, ","))
+	}
+
+	reloader := newConfigReloader(propertyFile, flags, settings, cfg, c, handler, tlsReloader, reg)
+	go config.Watch(reloader.files, configPollInterval, make(chan struct{}), func() {
+		logrus.Infoln("Configuration files changed")
+		_ = reloader.Reload()
+	})
+
+	if len(cfg.AdminAddr) > 0 {
+		startTime := time.Now()
+		adminServer := &admin.Server{
+			Token:    cfg.AdminToken,
+			Settings: reloader.Settings,
+			Status: func() map[string]interface{} {
+				status := reloader.Status()
+				status["startTime"] = startTime
+				return status
+			},
+			Reload: reloader.Reload,
+		}
+		go func() {
+			logrus.Println("admin starting to listen on ", cfg.AdminAddr)
+			if err := adminServer.ListenAndServe(cfg.AdminAddr); err != nil {
+				logrus.Errorln("Failed to start admin listener:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		<-signals
+		logrus.Infoln("Shutting down")
+		if reg != nil {
+			reg.unregisterOnUninstall(reloader.Config())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			logrus.Errorln("Failed to complete the requests in progress:", err)
+		}
+		close(stopped)
+	}()
+
+	logrus.Println("Webhook starting to listen on ", cfg.ListenAddr)
+	err = s.ListenAndServeTLS("", "")
+	if err != http.ErrServerClosed {
 		logrus.Errorln("Failed to start ListenAndServeTLS:", err)
 		os.Exit(1)
 	}
+	<-stopped
 }
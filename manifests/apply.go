@@ -0,0 +1,177 @@
+package manifests
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExtensionAPIServerAuthentication is the ConfigMap of kube-system with the client CA of the API server
+const ExtensionAPIServerAuthentication = "extension-apiserver-authentication"
+
+// ClusterCABundle returns the client CA of the API server, the default caBundle
+func ClusterCABundle(client kubernetes.Interface) ([]byte, error) {
+	configMap, err := client.CoreV1().ConfigMaps("kube-system").Get(ExtensionAPIServerAuthentication, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bundle, ok := configMap.Data["client-ca-file"]
+	if !ok || len(bundle) == 0 {
+		return nil, errors.New("kube-system/" + ExtensionAPIServerAuthentication + " without client-ca-file")
+	}
+	return []byte(bundle), nil
+}
+
+// Apply creates the objects or updates the existing ones. Webhooks without a caBundle keep the current one.
+func Apply(client kubernetes.Interface, objects []runtime.Object) error {
+	for _, object := range objects {
+		created, err := apply(client, object)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%T", object)
+		if accessor, ok := object.(metav1.Object); ok {
+			name = object.GetObjectKind().GroupVersionKind().Kind + " " + accessor.GetName()
+		}
+		if created {
+			logrus.Infoln("Created", name)
+		} else {
+			logrus.Infoln("Updated", name)
+		}
+	}
+	return nil
+}
+
+// apply creates or updates a single object and tells if it was created
+func apply(client kubernetes.Interface, object runtime.Object) (bool, error) {
+	switch o := object.(type) {
+	case *rbacv1.ClusterRole:
+		current, err := client.RbacV1().ClusterRoles().Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.RbacV1().ClusterRoles().Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		_, err = client.RbacV1().ClusterRoles().Update(o)
+		return false, err
+	case *rbacv1.ClusterRoleBinding:
+		current, err := client.RbacV1().ClusterRoleBindings().Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.RbacV1().ClusterRoleBindings().Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		_, err = client.RbacV1().ClusterRoleBindings().Update(o)
+		return false, err
+	case *rbacv1.Role:
+		current, err := client.RbacV1().Roles(o.Namespace).Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.RbacV1().Roles(o.Namespace).Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		_, err = client.RbacV1().Roles(o.Namespace).Update(o)
+		return false, err
+	case *rbacv1.RoleBinding:
+		current, err := client.RbacV1().RoleBindings(o.Namespace).Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.RbacV1().RoleBindings(o.Namespace).Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		_, err = client.RbacV1().RoleBindings(o.Namespace).Update(o)
+		return false, err
+	case *corev1.ServiceAccount:
+		// the token secrets of an existing service account are kept
+		_, err := client.CoreV1().ServiceAccounts(o.Namespace).Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.CoreV1().ServiceAccounts(o.Namespace).Create(o)
+			return true, err
+		}
+		return false, err
+	case *corev1.Service:
+		current, err := client.CoreV1().Services(o.Namespace).Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.CoreV1().Services(o.Namespace).Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		// the cluster IP can not be changed
+		o.ResourceVersion = current.ResourceVersion
+		o.Spec.ClusterIP = current.Spec.ClusterIP
+		_, err = client.CoreV1().Services(o.Namespace).Update(o)
+		return false, err
+	case *appsv1.Deployment:
+		current, err := client.AppsV1().Deployments(o.Namespace).Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = client.AppsV1().Deployments(o.Namespace).Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		_, err = client.AppsV1().Deployments(o.Namespace).Update(o)
+		return false, err
+	case *admissionregistrationv1beta1.MutatingWebhookConfiguration:
+		configs := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+		current, err := configs.Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configs.Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		caBundles := map[string][]byte{}
+		for _, webhook := range current.Webhooks {
+			caBundles[webhook.Name] = webhook.ClientConfig.CABundle
+		}
+		for i := range o.Webhooks {
+			if len(o.Webhooks[i].ClientConfig.CABundle) == 0 {
+				o.Webhooks[i].ClientConfig.CABundle = caBundles[o.Webhooks[i].Name]
+			}
+		}
+		_, err = configs.Update(o)
+		return false, err
+	case *admissionregistrationv1beta1.ValidatingWebhookConfiguration:
+		configs := client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+		current, err := configs.Get(o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configs.Create(o)
+			return true, err
+		} else if err != nil {
+			return false, err
+		}
+		o.ResourceVersion = current.ResourceVersion
+		caBundles := map[string][]byte{}
+		for _, webhook := range current.Webhooks {
+			caBundles[webhook.Name] = webhook.ClientConfig.CABundle
+		}
+		for i := range o.Webhooks {
+			if len(o.Webhooks[i].ClientConfig.CABundle) == 0 {
+				o.Webhooks[i].ClientConfig.CABundle = caBundles[o.Webhooks[i].Name]
+			}
+		}
+		_, err = configs.Update(o)
+		return false, err
+	}
+	return false, fmt.Errorf("can not apply %T", object)
+}
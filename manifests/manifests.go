@@ -0,0 +1,486 @@
+package manifests
+
+import (
+	"bytes"
+	"errors"
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/server"
+	"sort"
+	"strings"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// Options configure the rendered manifests
+type Options struct {
+	// Name of the Service, Deployment and webhook configurations
+	Name      string
+	Namespace string
+	Image     string
+	// ConfigMap with bh-admission.properties and resource-rules.yaml
+	ConfigMap string
+	// CertSecret with the serving certificate
+	CertSecret string
+	// WebhookDomain is appended to the webhook names
+	WebhookDomain string
+	// Port of the webhook listener
+	Port int32
+	// MetricsPort of the metrics listener, not exposed when 0
+	MetricsPort             int32
+	CABundle                []byte
+	MutatingFailurePolicy   admissionregistrationv1beta1.FailurePolicyType
+	ValidatingFailurePolicy admissionregistrationv1beta1.FailurePolicyType
+	TimeoutSeconds          int32
+	// WatchAPIServer grants access to the OpenShift APIServer configuration
+	WatchAPIServer bool
+	// RecordEvents grants access to create the events of the plugins in every namespace
+	RecordEvents bool
+	// CertMode grants access to the certificate Secret, the webhook configurations and
+	// the CSR API when the webhook manages its certificate
+	CertMode string
+	// SignerName the webhook may approve CertificateSigningRequests for, none when empty
+	ApproveSignerName string
+	// ReadClusterCA grants access to the client CA of the API server in kube-system
+	ReadClusterCA bool
+	// Register grants access to create the webhook configurations, which are then not rendered
+	Register bool
+	// NamespaceSelector and ObjectSelector of the webhooks, nil selects everything
+	NamespaceSelector *metav1.LabelSelector
+	ObjectSelector    *metav1.LabelSelector
+}
+
+// DefaultOptions returns the options of deploy.yaml
+func DefaultOptions() Options {
+	return Options{
+		Name:                    "bh-admission",
+		Namespace:               "bh-admission",
+		Image:                   "image-registry.openshift-image-registry.svc:5000/openshift/bh-admission:latest",
+		ConfigMap:               "bh-admission-config",
+		CertSecret:              "bh-admission-certs",
+		CertMode:                certs.ModeFile,
+		WebhookDomain:           "cust.local",
+		Port:                    8080,
+		MetricsPort:             2112,
+		MutatingFailurePolicy:   admissionregistrationv1beta1.Ignore,
+		ValidatingFailurePolicy: admissionregistrationv1beta1.Fail,
+		TimeoutSeconds:          10,
+	}
+}
+
+func (options *Options) serviceAccount() string {
+	return options.Name + "-sa"
+}
+
+func (options *Options) labels() map[string]string {
+	return map[string]string{"name": options.Name}
+}
+
+// MutatingWebhookConfigurationName returns the name of the mutating webhook configuration
+func (options *Options) MutatingWebhookConfigurationName() string {
+	return options.Name + "-mwc"
+}
+
+// ValidatingWebhookConfigurationName returns the name of the validating webhook configuration
+func (options *Options) ValidatingWebhookConfigurationName() string {
+	return options.Name + "-vwc"
+}
+
+// Render returns the RBAC, Service, Deployment and webhook configurations for the routes.
+// The webhook configurations are left out when the webhook registers itself.
+func Render(options Options, routes []server.Route) []runtime.Object {
+	objects := []runtime.Object{
+		clusterRole(options),
+		&corev1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: options.serviceAccount(), Namespace: options.Namespace},
+		},
+		clusterRoleBinding(options),
+	}
+	objects = append(objects, namespaceRBAC(options)...)
+	objects = append(objects, service(options), deployment(options))
+	if options.Register {
+		return objects
+	}
+	if mwc := MutatingWebhookConfiguration(options, routes); len(mwc.Webhooks) > 0 {
+		objects = append(objects, mwc)
+	}
+	if vwc := ValidatingWebhookConfiguration(options, routes); len(vwc.Webhooks) > 0 {
+		objects = append(objects, vwc)
+	}
+	return objects
+}
+
+// YAML returns the objects as a multi-document YAML stream
+func YAML(objects []runtime.Object) ([]byte, error) {
+	var out bytes.Buffer
+	for _, object := range objects {
+		content, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		// drop the empty fields of the generated types
+		var fields map[string]interface{}
+		if err := yaml.Unmarshal(content, &fields); err != nil {
+			return nil, err
+		}
+		delete(fields, "status")
+		if spec, ok := fields["spec"].(map[string]interface{}); ok {
+			if strategy, ok := spec["strategy"].(map[string]interface{}); ok && len(strategy) == 0 {
+				delete(spec, "strategy")
+			}
+		}
+		dropNull(fields)
+		if content, err = yaml.Marshal(fields); err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(content)
+	}
+	return out.Bytes(), nil
+}
+
+// dropNull removes the null values, for example the creationTimestamp of the metadata
+func dropNull(fields map[string]interface{}) {
+	for key, value := range fields {
+		switch v := value.(type) {
+		case nil:
+			delete(fields, key)
+		case map[string]interface{}:
+			dropNull(v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					dropNull(m)
+				}
+			}
+		}
+	}
+}
+
+func clusterRole(options Options) *rbacv1.ClusterRole {
+	role := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name + "-getter-cr"},
+		Rules: []rbacv1.PolicyRule{
+			{APIGroups: []string{"", "user.openshift.io"}, Resources: []string{"namespaces", "projects", "users", "serviceaccounts"}, Verbs: []string{"get"}},
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}},
+		},
+	}
+	if options.RecordEvents {
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}})
+	}
+	if options.WatchAPIServer {
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{APIGroups: []string{"config.openshift.io"}, Resources: []string{"apiservers"}, Verbs: []string{"get", "list", "watch"}})
+	}
+	if options.CertMode != certs.ModeFile || options.Register {
+		verbs := []string{"get", "list", "update"}
+		if options.Register {
+			verbs = append(verbs, "create", "delete")
+		}
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{APIGroups: []string{"admissionregistration.k8s.io"}, Resources: []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"}, Verbs: verbs})
+	}
+	if options.CertMode == certs.ModeCSR {
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{APIGroups: []string{"certificates.k8s.io"}, Resources: []string{"certificatesigningrequests"}, Verbs: []string{"create", "get", "delete"}})
+		if len(options.ApproveSignerName) > 0 {
+			role.Rules = append(role.Rules,
+				rbacv1.PolicyRule{APIGroups: []string{"certificates.k8s.io"}, Resources: []string{"certificatesigningrequests/approval"}, Verbs: []string{"update"}},
+				rbacv1.PolicyRule{APIGroups: []string{"certificates.k8s.io"}, Resources: []string{"signers"}, ResourceNames: []string{options.ApproveSignerName}, Verbs: []string{"approve"}})
+		}
+	}
+	return role
+}
+
+// namespaceRBAC lets the webhook keep its certificate in the Secret, detect its uninstall and
+// read the client CA of the API server
+func namespaceRBAC(options Options) []runtime.Object {
+	subjects := []rbacv1.Subject{
+		{Kind: "ServiceAccount", Name: options.serviceAccount(), Namespace: options.Namespace},
+	}
+	rules := []rbacv1.PolicyRule{}
+	if options.CertMode != certs.ModeFile {
+		rules = append(rules,
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"create"}},
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{options.CertSecret}, Verbs: []string{"get", "update"}})
+	}
+	if options.Register {
+		rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, ResourceNames: []string{options.Name}, Verbs: []string{"get"}})
+	}
+	objects := []runtime.Object{}
+	if len(rules) > 0 {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: options.Namespace},
+				Rules:      rules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: options.Namespace},
+				Subjects:   subjects,
+				RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: options.Name, APIGroup: "rbac.authorization.k8s.io"},
+			})
+	}
+	if options.ReadClusterCA {
+		objects = append(objects, &rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: options.Name + "-authentication-reader", Namespace: "kube-system"},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "extension-apiserver-authentication-reader", APIGroup: "rbac.authorization.k8s.io"},
+		})
+	}
+	return objects
+}
+
+func clusterRoleBinding(options Options) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name + "-getter-crb"},
+		Subjects: []rbacv1.Subject{
+			{Kind: "ServiceAccount", Name: options.serviceAccount(), Namespace: options.Namespace},
+		},
+		RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: options.Name + "-getter-cr", APIGroup: "rbac.authorization.k8s.io"},
+	}
+}
+
+func service(options Options) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: options.Namespace, Labels: options.labels()},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "webhook", Port: 443, TargetPort: intstr.FromInt(int(options.Port))},
+			},
+			Selector: options.labels(),
+		},
+	}
+}
+
+func deployment(options Options) *appsv1.Deployment {
+	replicas := int32(1)
+	readOnly := true
+	var optional *bool
+	if options.CertMode != certs.ModeFile {
+		// the webhook creates the Secret when it manages its certificate
+		optional = &readOnly
+	}
+	container := corev1.Container{
+		Name:            "webhook",
+		Image:           options.Image,
+		ImagePullPolicy: corev1.PullAlways,
+		Ports: []corev1.ContainerPort{
+			{Name: "webhook", ContainerPort: options.Port},
+		},
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+				corev1.ResourceCPU:    resource.MustParse("1"),
+			},
+			Requests: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("50Mi"),
+				corev1.ResourceCPU:    resource.MustParse("300m"),
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "webhook-certs", MountPath: "/etc/webhook/certs", ReadOnly: true},
+			{Name: options.ConfigMap, MountPath: "/etc/webhook/bh-admission-config", ReadOnly: true},
+		},
+		SecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnly},
+	}
+	if options.MetricsPort > 0 {
+		container.Ports = append(container.Ports, corev1.ContainerPort{Name: "metrics", ContainerPort: options.MetricsPort})
+	}
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.Name, Namespace: options.Namespace, Labels: options.labels()},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: options.labels()},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: options.labels()},
+				Spec: corev1.PodSpec{
+					Containers:         []corev1.Container{container},
+					ServiceAccountName: options.serviceAccount(),
+					Volumes: []corev1.Volume{
+						{Name: "webhook-certs", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: options.CertSecret, Optional: optional}}},
+						{Name: options.ConfigMap, VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: options.ConfigMap},
+						}}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// webhookName returns the name of the webhook of a route, for example bh-admission-mutate-namespaces.cust.local
+func (options *Options) webhookName(route server.Route) string {
+	name := options.Name
+	if path := strings.Trim(route.Path, "/"); len(path) > 0 {
+		name += "-" + strings.Replace(path, "/", "-", -1)
+	}
+	return name + "." + options.WebhookDomain
+}
+
+func (options *Options) clientConfig(route server.Route) admissionregistrationv1beta1.WebhookClientConfig {
+	path := route.Path
+	return admissionregistrationv1beta1.WebhookClientConfig{
+		Service: &admissionregistrationv1beta1.ServiceReference{
+			Name:      options.Name,
+			Namespace: options.Namespace,
+			Path:      &path,
+		},
+		CABundle: options.CABundle,
+	}
+}
+
+// Rules returns the admission rules for the plugins of a route. Rules with the same
+// operations, group and version are merged.
+func Rules(route server.Route) []admissionregistrationv1beta1.RuleWithOperations {
+	type ruleKey struct {
+		operations string
+		group      string
+		version    string
+	}
+	resources := map[ruleKey]map[string]bool{}
+	for _, plugin := range route.Chain.Plugins {
+		for _, rule := range plugin.Rules() {
+			operations := []string{}
+			for _, operation := range rule.Operations {
+				operations = append(operations, string(operation))
+			}
+			sort.Strings(operations)
+			version := rule.Version
+			if len(version) == 0 {
+				version = "*"
+			}
+			key := ruleKey{strings.Join(operations, ","), rule.Group, version}
+			if resources[key] == nil {
+				resources[key] = map[string]bool{}
+			}
+			resources[key][rule.Resource] = true
+		}
+	}
+	keys := []ruleKey{}
+	for key := range resources {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		if keys[i].version != keys[j].version {
+			return keys[i].version < keys[j].version
+		}
+		return keys[i].operations < keys[j].operations
+	})
+	rules := []admissionregistrationv1beta1.RuleWithOperations{}
+	for _, key := range keys {
+		rule := admissionregistrationv1beta1.RuleWithOperations{
+			Rule: admissionregistrationv1beta1.Rule{
+				APIGroups:   []string{key.group},
+				APIVersions: []string{key.version},
+			},
+		}
+		for _, operation := range strings.Split(key.operations, ",") {
+			rule.Operations = append(rule.Operations, admissionregistrationv1beta1.OperationType(operation))
+		}
+		for resource := range resources[key] {
+			rule.Resources = append(rule.Resources, resource)
+		}
+		sort.Strings(rule.Resources)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// CheckOverlap returns an error when a resource and operation are matched by the rules of two routes
+// of the same type. The API server would send these requests to both webhooks.
+func CheckOverlap(routes []server.Route) error {
+	seen := map[string]string{}
+	for _, route := range routes {
+		for _, rule := range Rules(route) {
+			for _, resource := range rule.Resources {
+				for _, operation := range rule.Operations {
+					key := string(route.Type) + " " + string(operation) + " " + rule.APIGroups[0] + "/" + rule.APIVersions[0] + "/" + resource
+					if path, ok := seen[key]; ok && path != route.Path {
+						return errors.New("routes " + path + " and " + route.Path + " both match " + string(operation) + " " + resource)
+					}
+					seen[key] = route.Path
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MutatingWebhookConfiguration returns a webhook for every mutating route. The webhooks of routes
+// with side effects are not reinvoked, a reinvocation would repeat them.
+func MutatingWebhookConfiguration(options Options, routes []server.Route) *admissionregistrationv1beta1.MutatingWebhookConfiguration {
+	mwc := &admissionregistrationv1beta1.MutatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1beta1", Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.MutatingWebhookConfigurationName()},
+	}
+	for _, route := range routes {
+		if route.Type != server.Mutating {
+			continue
+		}
+		failurePolicy := options.MutatingFailurePolicy
+		reinvocationPolicy := admissionregistrationv1beta1.IfNeededReinvocationPolicy
+		if route.Chain.HasSideEffects() {
+			reinvocationPolicy = admissionregistrationv1beta1.NeverReinvocationPolicy
+		}
+		timeout := options.TimeoutSeconds
+		// the plugins skip the external API and events of dry-run requests
+		sideEffects := admissionregistrationv1beta1.SideEffectClassNoneOnDryRun
+		mwc.Webhooks = append(mwc.Webhooks, admissionregistrationv1beta1.MutatingWebhook{
+			Name:                    options.webhookName(route),
+			ClientConfig:            options.clientConfig(route),
+			Rules:                   Rules(route),
+			NamespaceSelector:       options.NamespaceSelector,
+			ObjectSelector:          options.ObjectSelector,
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			ReinvocationPolicy:      &reinvocationPolicy,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1beta1"},
+		})
+	}
+	return mwc
+}
+
+// ValidatingWebhookConfiguration returns a webhook for every validating route
+func ValidatingWebhookConfiguration(options Options, routes []server.Route) *admissionregistrationv1beta1.ValidatingWebhookConfiguration {
+	vwc := &admissionregistrationv1beta1.ValidatingWebhookConfiguration{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1beta1", Kind: "ValidatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{Name: options.ValidatingWebhookConfigurationName()},
+	}
+	for _, route := range routes {
+		if route.Type != server.Validating {
+			continue
+		}
+		failurePolicy := options.ValidatingFailurePolicy
+		timeout := options.TimeoutSeconds
+		// the plugins skip the external API and events of dry-run requests
+		sideEffects := admissionregistrationv1beta1.SideEffectClassNoneOnDryRun
+		vwc.Webhooks = append(vwc.Webhooks, admissionregistrationv1beta1.ValidatingWebhook{
+			Name:                    options.webhookName(route),
+			ClientConfig:            options.clientConfig(route),
+			Rules:                   Rules(route),
+			NamespaceSelector:       options.NamespaceSelector,
+			ObjectSelector:          options.ObjectSelector,
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1beta1"},
+		})
+	}
+	return vwc
+}
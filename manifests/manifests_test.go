@@ -0,0 +1,205 @@
+package manifests
+
+import (
+	"namespace-admission-controller/certs"
+	"namespace-admission-controller/server"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testPlugin struct {
+	pluginType server.PluginType
+	rules      []server.PluginRule
+}
+
+func (p *testPlugin) Name() string {
+	return "test"
+}
+
+func (p *testPlugin) Type() server.PluginType {
+	return p.pluginType
+}
+
+func (p *testPlugin) Rules() []server.PluginRule {
+	return p.rules
+}
+
+func (p *testPlugin) Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	return nil
+}
+
+type sideEffectPlugin struct {
+	testPlugin
+}
+
+func (p *sideEffectPlugin) HasSideEffects() bool {
+	return true
+}
+
+var create = []v1beta1.Operation{v1beta1.Create}
+
+func testRoutes() []server.Route {
+	namespaces := &testPlugin{server.Mutating, []server.PluginRule{
+		{Operations: create, Version: "v1", Resource: "namespaces"},
+		{Operations: create, Group: "project.openshift.io", Version: "v1", Resource: "projects"},
+	}}
+	accounts := &testPlugin{server.Mutating, []server.PluginRule{
+		{Operations: create, Version: "v1", Resource: "serviceaccounts"},
+		{Operations: create, Group: "apps", Resource: "deployments"},
+	}}
+	naming := &testPlugin{server.Validating, []server.PluginRule{
+		{Operations: create, Version: "v1", Resource: "namespaces"},
+	}}
+	return []server.Route{
+		{Path: "/mutate", Type: server.Mutating, Chain: server.NewPluginChain(namespaces, accounts)},
+		{Path: "/validate/namespaces", Type: server.Validating, Chain: server.NewPluginChain(naming)},
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules := Rules(testRoutes()[0])
+	expected := []admissionregistrationv1beta1.RuleWithOperations{
+		{Operations: []admissionregistrationv1beta1.OperationType{"CREATE"}, Rule: admissionregistrationv1beta1.Rule{
+			APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces", "serviceaccounts"}}},
+		{Operations: []admissionregistrationv1beta1.OperationType{"CREATE"}, Rule: admissionregistrationv1beta1.Rule{
+			APIGroups: []string{"apps"}, APIVersions: []string{"*"}, Resources: []string{"deployments"}}},
+		{Operations: []admissionregistrationv1beta1.OperationType{"CREATE"}, Rule: admissionregistrationv1beta1.Rule{
+			APIGroups: []string{"project.openshift.io"}, APIVersions: []string{"v1"}, Resources: []string{"projects"}}},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	routes := testRoutes()
+	if err := CheckOverlap(routes); err != nil {
+		t.Error("Mutating and validating routes may match the same resources:", err)
+	}
+	routes = append(routes, server.Route{Path: "/mutate/namespaces", Type: server.Mutating, Chain: routes[0].Chain})
+	if err := CheckOverlap(routes); err == nil {
+		t.Error("Expected error for overlapping mutating routes")
+	}
+}
+
+func TestRender(t *testing.T) {
+	options := DefaultOptions()
+	options.CABundle = []byte("ca")
+	objects := Render(options, testRoutes())
+	if len(objects) != 7 {
+		t.Fatalf("Expected 7 objects, got %d", len(objects))
+	}
+	mwc := MutatingWebhookConfiguration(options, testRoutes())
+	if len(mwc.Webhooks) != 1 || mwc.Webhooks[0].Name != "bh-admission-mutate.cust.local" ||
+		*mwc.Webhooks[0].ClientConfig.Service.Path != "/mutate" || string(mwc.Webhooks[0].ClientConfig.CABundle) != "ca" {
+		t.Errorf("Unexpected mutating webhooks %+v", mwc.Webhooks)
+	}
+	if *mwc.Webhooks[0].SideEffects != admissionregistrationv1beta1.SideEffectClassNoneOnDryRun {
+		t.Error("Dry-run requests are not declared free of side effects")
+	}
+	if *mwc.Webhooks[0].ReinvocationPolicy != admissionregistrationv1beta1.IfNeededReinvocationPolicy {
+		t.Error("Expected reinvocation of the webhook without side effects")
+	}
+	routes := testRoutes()
+	routes[0].Chain.Plugins = append(routes[0].Chain.Plugins, &sideEffectPlugin{})
+	if mwc := MutatingWebhookConfiguration(options, routes); *mwc.Webhooks[0].ReinvocationPolicy != admissionregistrationv1beta1.NeverReinvocationPolicy {
+		t.Error("Webhook with side effects is reinvoked")
+	}
+	vwc := ValidatingWebhookConfiguration(options, testRoutes())
+	if len(vwc.Webhooks) != 1 || vwc.Webhooks[0].Name != "bh-admission-validate-namespaces.cust.local" ||
+		*vwc.Webhooks[0].FailurePolicy != admissionregistrationv1beta1.Fail {
+		t.Errorf("Unexpected validating webhooks %+v", vwc.Webhooks)
+	}
+	if _, err := YAML(objects); err != nil {
+		t.Error(err)
+	}
+
+	// a webhook requesting its certificate also gets the Secret, webhook configuration and CSR permissions
+	options.CertMode = certs.ModeCSR
+	options.ApproveSignerName = "example.com/webhooks"
+	options.ReadClusterCA = true
+	objects = Render(options, testRoutes())
+	if len(objects) != 10 {
+		t.Fatalf("Expected 10 objects, got %d", len(objects))
+	}
+	if rules := objects[0].(*rbacv1.ClusterRole).Rules; rules[len(rules)-1].ResourceNames[0] != "example.com/webhooks" {
+		t.Error("Missing approve permission for the signer", rules)
+	}
+}
+
+func TestApply(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	options := DefaultOptions()
+	if err := Apply(client, Render(options, testRoutes())); err != nil {
+		t.Fatal(err)
+	}
+	options.CABundle = []byte("rotated")
+	if err := Apply(client, Render(options, testRoutes())); err != nil {
+		t.Fatal(err)
+	}
+	mwc, err := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mwc.Webhooks[0].ClientConfig.CABundle) != "rotated" {
+		t.Error("caBundle was not updated")
+	}
+}
+
+func TestRegistrar(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	options := DefaultOptions()
+	options.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"env": "build"}}
+	registrar := NewRegistrar(client, options, testRoutes())
+	if err := registrar.Update(options, testRoutes()); err != nil {
+		t.Fatal(err)
+	}
+	configs := client.AdmissionregistrationV1beta1()
+	if _, err := configs.MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{}); err == nil {
+		t.Error("Registered without caBundle")
+	}
+	if err := registrar.SetCABundle([]byte("ca")); err != nil {
+		t.Fatal(err)
+	}
+	mwc, err := configs.MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mwc.Webhooks[0].ClientConfig.CABundle) != "ca" || mwc.Webhooks[0].NamespaceSelector.MatchLabels["env"] != "build" {
+		t.Errorf("Unexpected webhook %+v", mwc.Webhooks[0])
+	}
+
+	// a reload without validating routes removes the validating configuration
+	if err := registrar.Update(options, testRoutes()[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configs.ValidatingWebhookConfigurations().Get("bh-admission-vwc", metav1.GetOptions{}); err == nil {
+		t.Error("The validating configuration without webhooks was kept")
+	}
+	if mwc, _ := configs.MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{}); string(mwc.Webhooks[0].ClientConfig.CABundle) != "ca" {
+		t.Error("The caBundle was not kept")
+	}
+
+	if uninstalling, err := Uninstalling(client, options); err != nil || !uninstalling {
+		t.Error("Expected uninstall without Deployment", err)
+	}
+	if err := Apply(client, []runtime.Object{deployment(options)}); err != nil {
+		t.Fatal(err)
+	}
+	if uninstalling, err := Uninstalling(client, options); err != nil || uninstalling {
+		t.Error("Unexpected uninstall with Deployment", err)
+	}
+	if err := Unregister(client, options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configs.MutatingWebhookConfigurations().Get("bh-admission-mwc", metav1.GetOptions{}); err == nil {
+		t.Error("The mutating configuration was not removed")
+	}
+}
@@ -0,0 +1,113 @@
+package manifests
+
+import (
+	"namespace-admission-controller/server"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Registrar keeps the webhook configurations of the running webhook up to date
+type Registrar struct {
+	client   kubernetes.Interface
+	mutex    sync.Mutex
+	options  Options
+	routes   []server.Route
+	caBundle []byte
+}
+
+// NewRegistrar returns a registrar for the webhook configurations of the options.
+// Nothing is registered before the caBundle is set.
+func NewRegistrar(client kubernetes.Interface, options Options, routes []server.Route) *Registrar {
+	return &Registrar{client: client, options: options, routes: routes}
+}
+
+// SetCABundle registers the webhook configurations with a new caBundle
+func (registrar *Registrar) SetCABundle(caBundle []byte) error {
+	registrar.mutex.Lock()
+	defer registrar.mutex.Unlock()
+	registrar.caBundle = caBundle
+	return registrar.register()
+}
+
+// Update registers the webhook configurations with new options and routes, the caBundle is kept
+func (registrar *Registrar) Update(options Options, routes []server.Route) error {
+	registrar.mutex.Lock()
+	defer registrar.mutex.Unlock()
+	registrar.options = options
+	registrar.routes = routes
+	if len(registrar.caBundle) == 0 {
+		return nil
+	}
+	return registrar.register()
+}
+
+func (registrar *Registrar) register() error {
+	if err := CheckOverlap(registrar.routes); err != nil {
+		return err
+	}
+	options := registrar.options
+	options.CABundle = registrar.caBundle
+	mwc := MutatingWebhookConfiguration(options, registrar.routes)
+	vwc := ValidatingWebhookConfiguration(options, registrar.routes)
+	// a configuration without webhooks is removed, the API server rejects it
+	if len(mwc.Webhooks) > 0 {
+		if err := Apply(registrar.client, []runtime.Object{mwc}); err != nil {
+			return err
+		}
+	} else if err := deleteMutating(registrar.client, mwc.Name); err != nil {
+		return err
+	}
+	if len(vwc.Webhooks) > 0 {
+		if err := Apply(registrar.client, []runtime.Object{vwc}); err != nil {
+			return err
+		}
+	} else if err := deleteValidating(registrar.client, vwc.Name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func deleteMutating(client kubernetes.Interface, name string) error {
+	err := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Delete(name, &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err == nil {
+		logrus.Infoln("Deleted MutatingWebhookConfiguration", name)
+	}
+	return err
+}
+
+func deleteValidating(client kubernetes.Interface, name string) error {
+	err := client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Delete(name, &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err == nil {
+		logrus.Infoln("Deleted ValidatingWebhookConfiguration", name)
+	}
+	return err
+}
+
+// Unregister deletes the webhook configurations of the options
+func Unregister(client kubernetes.Interface, options Options) error {
+	if err := deleteMutating(client, options.MutatingWebhookConfigurationName()); err != nil {
+		return err
+	}
+	return deleteValidating(client, options.ValidatingWebhookConfigurationName())
+}
+
+// Uninstalling tells if the Deployment of the webhook is deleted, the webhook configurations should
+// then be removed instead of being left behind pointing to a missing Service
+func Uninstalling(client kubernetes.Interface, options Options) (bool, error) {
+	deployment, err := client.AppsV1().Deployments(options.Namespace).Get(options.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return deployment.DeletionTimestamp != nil, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/manifests"
+	"namespace-admission-controller/server"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// registrationRetryInterval is the interval for retrying a failed registration
+const registrationRetryInterval = time.Minute
+
+// registration owns the webhook configurations when webhook_registration is enabled
+type registration struct {
+	client kubernetes.Interface
+	// namespace of the webhook when cert_namespace is empty
+	namespace string
+	registrar *manifests.Registrar
+}
+
+func newRegistration(cfg *config.Config, restConfig *restclient.Config, namespace string, routes []server.Route) (*registration, error) {
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	r := &registration{client: client, namespace: namespace}
+	options, err := r.options(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.registrar = manifests.NewRegistrar(client, options, routes)
+	return r, nil
+}
+
+func (r *registration) options(cfg *config.Config) (manifests.Options, error) {
+	options := manifests.DefaultOptions()
+	options.Namespace = r.namespace
+	return webhookOptions(cfg, options)
+}
+
+// update registers the rules and settings of a reloaded configuration
+func (r *registration) update(cfg *config.Config, routes []server.Route) error {
+	options, err := r.options(cfg)
+	if err != nil {
+		return err
+	}
+	return r.registrar.Update(options, routes)
+}
+
+// registerWithCABundleFile registers the webhook configurations with the CA bundle of
+// cert_ca_bundle_file or the client CA of the API server, retrying until it succeeds.
+// It is used with cert_mode=file, the bootstrapper registers after every rotation otherwise.
+func (r *registration) registerWithCABundleFile(cfg *config.Config) {
+	for {
+		var caBundle []byte
+		var err error
+		if len(cfg.CertCABundleFile) > 0 {
+			caBundle, err = ioutil.ReadFile(cfg.CertCABundleFile)
+		} else {
+			caBundle, err = manifests.ClusterCABundle(r.client)
+		}
+		if err == nil {
+			if err = r.registrar.SetCABundle(caBundle); err == nil {
+				return
+			}
+		}
+		logrus.Errorln("Failed to register the webhook configurations, retrying in", registrationRetryInterval, ":", err)
+		time.Sleep(registrationRetryInterval)
+	}
+}
+
+// unregisterOnUninstall removes the webhook configurations when the Deployment of the webhook
+// is deleted. A webhook that is only restarted or rolled out keeps them.
+func (r *registration) unregisterOnUninstall(cfg *config.Config) {
+	options, err := r.options(cfg)
+	if err != nil {
+		logrus.Errorln("Failed to unregister the webhook configurations:", err)
+		return
+	}
+	uninstalling, err := manifests.Uninstalling(r.client, options)
+	if err != nil {
+		logrus.Errorln("Keeping the webhook configurations, failed to check the Deployment:", err)
+		return
+	}
+	if !uninstalling {
+		return
+	}
+	logrus.Infoln("Deployment", options.Namespace+"/"+options.Name, "is deleted, removing the webhook configurations")
+	if err := manifests.Unregister(r.client, options); err != nil {
+		logrus.Errorln("Failed to unregister the webhook configurations:", err)
+	}
+}
+
+// runUninstall implements the uninstall command and returns the exit code
+func runUninstall(args []string) int {
+	flags := newFlagSet("uninstall")
+	propertyFile := flags.String("config", "configmap.yaml", "bh-admission.properties or ConfigMap YAML")
+	namespace := flags.String("namespace", manifests.DefaultOptions().Namespace, "namespace of the webhook when cert_namespace is empty")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: uninstall [flags]")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	cfg, _, errs := loadConfigFile(*propertyFile, flags)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, *propertyFile+":", err)
+		}
+		return 1
+	}
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	).ClientConfig()
+	if err != nil {
+		logrus.Errorln("Invalid kubeconfig:", err)
+		return 1
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		logrus.Errorln("Invalid kubeconfig:", err)
+		return 1
+	}
+	options := manifests.DefaultOptions()
+	options.Namespace = *namespace
+	if options, err = webhookOptions(cfg, options); err != nil {
+		logrus.Errorln(err)
+		return 1
+	}
+	if err := manifests.Unregister(client, options); err != nil {
+		logrus.Errorln("Failed to remove the webhook configurations:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"namespace-admission-controller/capture"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/server"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bhadmission_config_reloads_total",
+		Help: "The total number of configuration reloads by result",
+	}, []string{"result"})
+	configReloadTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bhadmission_config_last_reload_success_timestamp_seconds",
+		Help: "The time of the last successful configuration reload",
+	})
+)
+
+// configReloader validates a changed configuration and swaps it into the running webhook
+type configReloader struct {
+	mutex        sync.Mutex
+	propertyFile string
+	flags        *pflag.FlagSet
+	settings     *viper.Viper
+	config       *config.Config
+	clients      *clients
+	handler      *server.ReloadableHandler
+	tls          *server.TLSReloader
+	registration *registration
+	lastReload   time.Time
+	lastError    error
+}
+
+func newConfigReloader(propertyFile string, flags *pflag.FlagSet, settings *viper.Viper, cfg *config.Config, c *clients, handler *server.ReloadableHandler, tls *server.TLSReloader, reg *registration) *configReloader {
+	return &configReloader{
+		propertyFile: propertyFile,
+		flags:        flags,
+		settings:     settings,
+		config:       cfg,
+		clients:      c,
+		handler:      handler,
+		tls:          tls,
+		registration: reg,
+	}
+}
+
+// files returns the watched configuration files
+func (reloader *configReloader) files() []string {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	return []string{reloader.propertyFile, reloader.config.ResourceRulesFile}
+}
+
+// Reload reads and validates the configuration and replaces the running one.
+// The running configuration is kept when the new one is invalid.
+func (reloader *configReloader) Reload() error {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	err := reloader.reload()
+	reloader.lastError = err
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		logrus.Errorln("Keeping the running configuration, reload failed:", err)
+		return err
+	}
+	reloader.lastReload = time.Now()
+	configReloads.WithLabelValues("success").Inc()
+	configReloadTime.SetToCurrentTime()
+	logrus.Infoln("Configuration reloaded")
+	return nil
+}
+
+func (reloader *configReloader) reload() error {
+	settings, err := config.Read(reloader.propertyFile, reloader.flags)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(settings)
+	if err != nil {
+		return err
+	}
+	routes, err := reloader.clients.newRoutes(cfg)
+	if err != nil {
+		return errors.New("invalid routes: " + err.Error())
+	}
+	reloader.warnRestartRequired(cfg)
+
+	// every step that can fail runs before the new routes are served
+	updateTLS := cfg.TLSProfileSource == config.TLSProfileSourceStatic && reloader.config.TLSProfileSource == config.TLSProfileSourceStatic &&
+		!reflect.DeepEqual(cfg.TLS, reloader.config.TLS)
+	if updateTLS {
+		if _, err := cfg.TLS.TLSConfig(); err != nil {
+			return err
+		}
+	}
+	update, err := prepareLogging(cfg)
+	if err != nil {
+		return err
+	}
+	if updateTLS {
+		if err := reloader.tls.Update(cfg.TLS); err != nil {
+			update.discard()
+			return err
+		}
+	}
+	update.apply()
+	warnConfig(cfg)
+	if reloader.registration != nil && cfg.WebhookRegistration {
+		// the new routes are served even when the webhook configurations could not be updated
+		if err := reloader.registration.update(cfg, routes); err != nil {
+			logrus.Errorln("Failed to register the reloaded webhook configurations:", err)
+		}
+	}
+	reloader.handler.Swap(server.NewRouter(routes, cfg.MaxRequestBytes))
+	reloader.settings = settings
+	reloader.config = cfg
+	return nil
+}
+
+// warnRestartRequired logs the changed settings that only apply after a restart
+func (reloader *configReloader) warnRestartRequired(cfg *config.Config) {
+	current := reloader.config
+	changed := map[string]bool{
+		"listen_addr":          cfg.ListenAddr != current.ListenAddr,
+		"metrics_addr":         cfg.MetricsAddr != current.MetricsAddr,
+		"admin_addr":           cfg.AdminAddr != current.AdminAddr,
+		"admin_token":          cfg.AdminToken != current.AdminToken,
+		"client_ca_file":       cfg.ClientCAFile != current.ClientCAFile,
+		"allowed_client_names": !reflect.DeepEqual(cfg.AllowedClientNames, current.AllowedClientNames),
+		"tls_profile_source":   cfg.TLSProfileSource != current.TLSProfileSource,
+		"http2_enabled":        cfg.TLS.HTTP2 != current.TLS.HTTP2,
+		"cert_mode":            cfg.CertMode != current.CertMode,
+		"webhook_registration": cfg.WebhookRegistration != current.WebhookRegistration,
+	}
+	for key, restart := range changed {
+		if restart {
+			logrus.Warnln("Changed " + key + " applies after a restart")
+		}
+	}
+}
+
+// Config returns the running configuration
+func (reloader *configReloader) Config() *config.Config {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	return reloader.config
+}
+
+// Settings returns the settings of the running configuration
+func (reloader *configReloader) Settings() map[string]interface{} {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	return reloader.settings.AllSettings()
+}
+
+// Status returns the routes of the running configuration and the result of the last reload
+func (reloader *configReloader) Status() map[string]interface{} {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	status := map[string]interface{}{
+		"tlsProfileSource": reloader.config.TLSProfileSource,
+		"externalAPIMode":  reloader.config.ExternalAPIMode,
+		"routes":           reloader.config.Routes,
+	}
+	if !reloader.lastReload.IsZero() {
+		status["lastReload"] = reloader.lastReload
+	}
+	if reloader.lastError != nil {
+		status["lastReloadError"] = reloader.lastError.Error()
+	}
+	// the capture buffer is the only queue of the webhook, requests are not retried or held back by a breaker
+	status["capture"], _ = capture.CurrentStats()
+	return status
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/server"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bh-admission.properties")
+	write := func(content string) {
+		content += "\nresource_rules_file=" + filepath.Join(dir, "resource-rules.yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("routes=/mutate=owner-annotation")
+	settings, _ := config.Read(path, nil)
+	cfg, err := config.Load(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &clients{}
+	handler := server.NewReloadableHandler(http.NotFoundHandler())
+	reloader := newConfigReloader(path, nil, settings, cfg, c, handler, nil, nil)
+
+	write("routes=/validate=owner-annotation")
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected error for mutating plugin in validating route")
+	}
+	if reloader.config != cfg || reloader.Status()["lastReloadError"] == nil {
+		t.Error("Invalid configuration replaced the running configuration")
+	}
+
+	// the routes and the logging settings are valid but the capture file can not be opened
+	level, formatter := logrus.GetLevel(), logrus.StandardLogger().Formatter
+	write("routes=/mutate=owner-annotation\nlog_level=trace\nlog_format=text\ncapture_output=" + filepath.Join(dir, "missing", "reviews.jsonl"))
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected error for a capture file in a missing directory")
+	}
+	if logrus.GetLevel() != level || logrus.StandardLogger().Formatter != formatter {
+		t.Error("Failed reload changed the logging settings")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mutate", nil))
+	if reloader.config != cfg || w.Code != http.StatusNotFound {
+		t.Error("Failed reload replaced the running routes")
+	}
+
+	write("routes=/mutate=owner-annotation,external-registration\nexternal_api_mode=gate\nexternal_api_url=https://external-api.example.com/register")
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if reloader.config.ExternalAPIMode != "gate" || reloader.Settings()["external_api_mode"] != "gate" {
+		t.Error("Configuration was not replaced")
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"namespace-admission-controller/config"
+	"namespace-admission-controller/server"
+	"namespace-admission-controller/webhook/webhooktest"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	userv1 "github.com/openshift/api/user/v1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// maxReplayLineBytes is the size of the largest AdmissionReview of a corpus
+const maxReplayLineBytes = 4 * 1024 * 1024
+
+// replayOptions describe the cluster and the external API seen by the replayed requests
+type replayOptions struct {
+	// objects are the Namespaces, ServiceAccounts and Users that already exist
+	objects []runtime.Object
+	// externalStatus and externalResponse are returned by the stub external API
+	externalStatus   int
+	externalResponse string
+}
+
+// replayResult is the decision of one route for one AdmissionReview of the corpus
+type replayResult struct {
+	Line      int               `json:"line"`
+	UID       types.UID         `json:"uid"`
+	Operation v1beta1.Operation `json:"operation"`
+	Kind      string            `json:"kind"`
+	Namespace string            `json:"namespace,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Route     string            `json:"route,omitempty"`
+	// Ignored is set when no route handles the request
+	Ignored bool        `json:"ignored,omitempty"`
+	Allowed bool        `json:"allowed"`
+	Message string      `json:"message,omitempty"`
+	Patch   interface{} `json:"patch,omitempty"`
+	// External are the bodies sent to the external API
+	External []interface{} `json:"external,omitempty"`
+}
+
+// loadReplayObjects reads the existing objects of a replay from a YAML or JSON file with one or more documents
+func loadReplayObjects(file string) ([]runtime.Object, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	objects := []runtime.Object{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return objects, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal(raw.Raw, &typeMeta); err != nil {
+			return nil, err
+		}
+		var object runtime.Object
+		switch typeMeta.Kind {
+		case "Namespace":
+			object = &corev1.Namespace{}
+		case "ServiceAccount":
+			object = &corev1.ServiceAccount{}
+		case "User":
+			object = &userv1.User{}
+		default:
+			return nil, errors.New("unsupported kind " + typeMeta.Kind + ", expected Namespace, ServiceAccount or User")
+		}
+		if err := json.Unmarshal(raw.Raw, object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+}
+
+// replayRoutes builds the routes of the configuration with fake clients holding the objects
+func replayRoutes(cfg *config.Config, objects []runtime.Object) ([]server.Route, error) {
+	c := &clients{webhook: webhooktest.NewCluster(objects...).Clients()}
+	return c.newRoutes(cfg)
+}
+
+// replay runs every AdmissionReview of the corpus through the routes of the configuration and
+// returns one JSON line per route handling it. The routes run in their configured order and all
+// see the original object, the patches of the mutating routes are not applied.
+func replay(cfg *config.Config, corpus io.Reader, options replayOptions) ([]byte, error) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	externalAPI.Respond(options.externalStatus, options.externalResponse)
+	// the external API is only called when it is configured
+	replayConfig := *cfg
+	if len(replayConfig.ExternalAPIURL) > 0 {
+		replayConfig.ExternalAPIURL = externalAPI.URL()
+	}
+	routes, err := replayRoutes(&replayConfig, options.objects)
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	err = scanReviews(corpus, func(line int, review *v1beta1.AdmissionReview) error {
+		results := []replayResult{}
+		for _, route := range routes {
+			routeReview := &v1beta1.AdmissionReview{Request: review.Request.DeepCopy()}
+			if err := route.Chain.HandleAdmission(routeReview); err != nil {
+				return fmt.Errorf("route %s: %v", route.Path, err)
+			}
+			external := decodeCalls(externalAPI.Take())
+			if routeReview.Response == nil {
+				continue
+			}
+			result, err := newReplayResult(line, review.Request, routeReview.Response)
+			if err != nil {
+				return fmt.Errorf("route %s: %v", route.Path, err)
+			}
+			result.Route = route.Path
+			result.External = external
+			results = append(results, result)
+		}
+		if len(results) == 0 {
+			result, _ := newReplayResult(line, review.Request, &v1beta1.AdmissionResponse{Allowed: true})
+			result.Ignored = true
+			results = append(results, result)
+		}
+		for _, result := range results {
+			encoded, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			output.Write(encoded)
+			output.WriteByte('\n')
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// scanReviews calls handle with every AdmissionReview of a JSONL corpus, blank lines are skipped.
+// The errors are prefixed with the line number.
+func scanReviews(corpus io.Reader, handle func(line int, review *v1beta1.AdmissionReview) error) error {
+	scanner := bufio.NewScanner(corpus)
+	scanner.Buffer(make([]byte, 64*1024), maxReplayLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		review := &v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(text, review); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if review.Request == nil {
+			return fmt.Errorf("line %d: AdmissionReview without request", line)
+		}
+		if err := handle(line, review); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("line %d: %v", line+1, err)
+	}
+	return nil
+}
+
+// decodeCalls decodes the JSON bodies sent to the external API for printing them as JSON
+func decodeCalls(calls [][]byte) []interface{} {
+	decoded := []interface{}{}
+	for _, call := range calls {
+		var body interface{}
+		if err := json.Unmarshal(call, &body); err != nil {
+			body = string(call)
+		}
+		decoded = append(decoded, body)
+	}
+	return decoded
+}
+
+func newReplayResult(line int, request *v1beta1.AdmissionRequest, response *v1beta1.AdmissionResponse) (replayResult, error) {
+	result := replayResult{
+		Line:      line,
+		UID:       request.UID,
+		Operation: request.Operation,
+		Kind:      request.Kind.Kind,
+		Namespace: request.Namespace,
+		Name:      request.Name,
+		Allowed:   response.Allowed,
+	}
+	if response.Result != nil {
+		result.Message = response.Result.Message
+	}
+	if len(response.Patch) > 0 {
+		if err := json.Unmarshal(response.Patch, &result.Patch); err != nil {
+			return result, errors.New("invalid patch: " + err.Error())
+		}
+	}
+	return result, nil
+}
+
+// diffLines returns the lines that differ between the golden output and the replay output
+func diffLines(golden, output []byte) []string {
+	goldenLines := strings.Split(strings.TrimSuffix(string(golden), "\n"), "\n")
+	outputLines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	diff := []string{}
+	for i := 0; i < len(goldenLines) || i < len(outputLines); i++ {
+		var want, got string
+		if i < len(goldenLines) {
+			want = goldenLines[i]
+		}
+		if i < len(outputLines) {
+			got = outputLines[i]
+		}
+		if want == got {
+			continue
+		}
+		diff = append(diff, "@@ line "+strconv.Itoa(i+1))
+		if i < len(goldenLines) {
+			diff = append(diff, "- "+want)
+		}
+		if i < len(outputLines) {
+			diff = append(diff, "+ "+got)
+		}
+	}
+	return diff
+}
+
+// runReplay implements the replay command and returns the exit code
+func runReplay(args []string) int {
+	flags := newFlagSet("replay")
+	propertyFile := flags.String("config", "configmap.yaml", "bh-admission.properties or ConfigMap YAML")
+	objectsFile := flags.String("objects", "", "YAML file with the Namespaces, ServiceAccounts and Users that already exist")
+	externalStatus := flags.Int("external-status", http.StatusOK, "HTTP status returned by the stub external API")
+	externalResponse := flags.String("external-response", `{"allowed": true}`, "body returned by the stub external API")
+	golden := flags.String("golden", "", "compare the output with this file instead of printing it")
+	updateGolden := flags.Bool("update-golden", false, "write the output to the --golden file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] <AdmissionReview JSONL file, - for stdin>")
+		flags.PrintDefaults()
+	}
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	args = flags.Args()
+	if len(args) != 1 || (*updateGolden && len(*golden) == 0) {
+		flags.Usage()
+		return 2
+	}
+	cfg, _, errs := loadConfigFile(*propertyFile, flags)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, *propertyFile+":", err)
+		}
+		return 1
+	}
+	if err := configureLogging(cfg); err != nil {
+		logrus.Errorln("Invalid logging configuration:", err)
+		return 1
+	}
+	options := replayOptions{externalStatus: *externalStatus, externalResponse: *externalResponse}
+	if len(*objectsFile) > 0 {
+		objects, err := loadReplayObjects(*objectsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, *objectsFile+":", err)
+			return 1
+		}
+		options.objects = objects
+	}
+	corpus := io.Reader(os.Stdin)
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		corpus = f
+	}
+	output, err := replay(cfg, corpus, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, args[0]+":", err)
+		return 1
+	}
+	if len(*golden) == 0 {
+		_, _ = os.Stdout.Write(output)
+		return 0
+	}
+	if *updateGolden {
+		if err := ioutil.WriteFile(*golden, output, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	expected, err := ioutil.ReadFile(*golden)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if diff := diffLines(expected, output); len(diff) > 0 {
+		fmt.Fprintln(os.Stderr, *golden+": replay output differs, run with --update-golden to accept it")
+		fmt.Fprintln(os.Stderr, strings.Join(diff, "\n"))
+		return 1
+	}
+	fmt.Println(*golden + ": replay output matches")
+	return 0
+}
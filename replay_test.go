@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReplayGolden(t *testing.T) {
+	cfg, _, errs := loadConfigFile("configmap.yaml", nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	objects, err := loadReplayObjects("testing/replay/objects.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := os.Open("testing/replay/reviews.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corpus.Close()
+	output, err := replay(cfg, corpus, replayOptions{objects: objects, externalStatus: 200, externalResponse: `{"allowed": true}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ioutil.ReadFile("testing/replay/golden.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := diffLines(golden, output); len(diff) > 0 {
+		t.Error("testing/replay/golden.jsonl is out of date, run: go run . replay --objects testing/replay/objects.yaml " +
+			"--golden testing/replay/golden.jsonl --update-golden testing/replay/reviews.jsonl\n" + strings.Join(diff, "\n"))
+	}
+}
+
+func TestReplayExternalGate(t *testing.T) {
+	flags := newFlagSet("replay")
+	if err := flags.Parse([]string{"--external-api-mode=gate"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, errs := loadConfigFile("configmap.yaml", flags)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	corpus, err := os.Open("testing/replay/reviews.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corpus.Close()
+	output, err := replay(cfg, corpus, replayOptions{externalStatus: 200, externalResponse: `{"allowed": false, "reason": "not approved"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first review of the corpus runs through the owner route, then the validating route
+	validated := strings.SplitN(string(output), "\n", 3)[1]
+	if !strings.Contains(validated, `"route":"/validate","allowed":false,"message":"not approved"`) || !strings.Contains(validated, `"type":"namespace"`) {
+		t.Error("namespace was not denied by the external API gate:", validated)
+	}
+}
+
+func TestReplayInvalidLine(t *testing.T) {
+	cfg, _, errs := loadConfigFile("configmap.yaml", nil)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	_, err := replay(cfg, strings.NewReader("\n{\"kind\":\"AdmissionReview\"}\n"), replayOptions{externalStatus: 200})
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Error("expected an error for line 2, got", err)
+	}
+}
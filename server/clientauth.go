@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+)
+
+var unauthenticatedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bhadmission_unauthenticated_requests",
+	Help: "The total number of requests rejected because the client certificate is missing or not allowed",
+}, []string{"reason"})
+
+// clientAuthenticator only passes requests with a verified client certificate whose
+// common name or DNS names are allowed
+type clientAuthenticator struct {
+	allowedNames map[string]bool
+	next         http.Handler
+}
+
+func (auth *clientAuthenticator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		unauthenticatedRequests.WithLabelValues("no_certificate").Inc()
+		logrus.WithFields(logrus.Fields{
+			"RemoteAddr": r.RemoteAddr,
+		}).Warn("Rejecting request without verified client certificate")
+		writeError(w, http.StatusUnauthorized, "a client certificate is required")
+		return
+	}
+	if len(auth.allowedNames) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		allowed := auth.allowedNames[cert.Subject.CommonName]
+		for _, name := range cert.DNSNames {
+			allowed = allowed || auth.allowedNames[name]
+		}
+		if !allowed {
+			unauthenticatedRequests.WithLabelValues("name_not_allowed").Inc()
+			logrus.WithFields(logrus.Fields{
+				"RemoteAddr": r.RemoteAddr,
+				"Subject":    cert.Subject.String(),
+			}).Warn("Rejecting request from client certificate that is not allowed")
+			writeError(w, http.StatusForbidden, "client certificate "+cert.Subject.CommonName+" is not allowed")
+			return
+		}
+	}
+	auth.next.ServeHTTP(w, r)
+}
+
+// RequireClientCertificates verifies client certificates against the CA bundle in clientCAFile
+// and rejects requests without a valid certificate. When allowedNames is not empty, the common
+// name or one of the DNS names of the certificate must be in the list.
+func RequireClientCertificates(server *http.Server, clientCAFile string, allowedNames []string) error {
+	caBundle, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBundle) {
+		return errors.New("no certificates found in " + clientCAFile)
+	}
+	if server.TLSConfig == nil {
+		server.TLSConfig = &tls.Config{}
+	}
+	server.TLSConfig.ClientCAs = pool
+	// verify certificates during the handshake, but reject missing ones in the handler
+	// so that the rejection is recorded in the metrics
+	server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+
+	names := map[string]bool{}
+	for _, name := range allowedNames {
+		if len(name) > 0 {
+			names[name] = true
+		}
+	}
+	server.Handler = &clientAuthenticator{
+		allowedNames: names,
+		next:         server.Handler,
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCertificate creates a certificate signed by parent, or a self-signed CA when parent is nil
+func newCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestRequireClientCertificates(t *testing.T) {
+	ca, caKey, _ := newCertificate(t, "client-ca", nil, nil)
+	_, _, apiServerCert := newCertificate(t, "system:kube-apiserver", ca, caKey)
+	_, _, otherCert := newCertificate(t, "someone", ca, caKey)
+	otherCA, otherCAKey, _ := newCertificate(t, "other-ca", nil, nil)
+	_, _, untrustedCert := newCertificate(t, "system:kube-apiserver", otherCA, otherCAKey)
+
+	dir, err := ioutil.TempDir("", "clientauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	_ = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600)
+
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	if err := RequireClientCertificates(s, caFile, []string{"system:kube-apiserver"}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(s.Handler)
+	ts.TLS = s.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		code  int
+	}{
+		{"allowed", []tls.Certificate{apiServerCert}, http.StatusOK},
+		{"no certificate", nil, http.StatusUnauthorized},
+		{"name not allowed", []tls.Certificate{otherCert}, http.StatusForbidden},
+	}
+	newClient := func(certs []tls.Certificate) *http.Client {
+		roots := x509.NewCertPool()
+		roots.AddCert(ts.Certificate())
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+	}
+	for _, test := range tests {
+		client := newClient(test.certs)
+		r, err := client.Post(ts.URL, "application/json", nil)
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		r.Body.Close()
+		if r.StatusCode != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.code, r.StatusCode)
+		}
+	}
+
+	// the handshake fails, or the client does not offer a certificate the server does not trust
+	if r, err := newClient([]tls.Certificate{untrustedCert}).Post(ts.URL, "application/json", nil); err == nil {
+		r.Body.Close()
+		if r.StatusCode != http.StatusUnauthorized {
+			t.Error("Certificate of an untrusted CA was accepted")
+		}
+	}
+}
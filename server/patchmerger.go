@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// EscapeJSONPointer escapes a map key for use in a JSON pointer (RFC 6901)
+func EscapeJSONPointer(key string) string {
+	return jsonPointerEscaper.Replace(key)
+}
+
+// patchMerger concatenates the JSON patches of several plugins. When a plugin adds
+// a map that an earlier plugin already added, for example /metadata/annotations of
+// an object without annotations, its keys are added to the existing map instead of
+// replacing it.
+type patchMerger struct {
+	operations []patchOperation
+	added      map[string]bool
+}
+
+func (merger *patchMerger) add(patchBytes []byte) error {
+	var patch []patchOperation
+	if err := json.Unmarshal(patchBytes, &patch); err != nil {
+		return err
+	}
+	if merger.added == nil {
+		merger.added = map[string]bool{}
+	}
+	for _, po := range patch {
+		if po.Op != "add" {
+			merger.operations = append(merger.operations, po)
+			continue
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(po.Value, &values); err != nil {
+			// not a map
+			merger.operations = append(merger.operations, po)
+			continue
+		}
+		if !merger.added[po.Path] {
+			merger.added[po.Path] = true
+			merger.operations = append(merger.operations, po)
+			continue
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			merger.operations = append(merger.operations, patchOperation{
+				Op:    "add",
+				Path:  po.Path + "/" + EscapeJSONPointer(k),
+				Value: values[k],
+			})
+		}
+	}
+	return nil
+}
+
+func (merger *patchMerger) bytes() ([]byte, error) {
+	if len(merger.operations) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(merger.operations)
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"namespace-admission-controller/logging"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// PluginType tells whether a plugin mutates or validates requests
+type PluginType string
+
+const (
+	// Mutating plugins may return a JSON patch
+	Mutating PluginType = "mutating"
+	// Validating plugins only allow or deny requests
+	Validating PluginType = "validating"
+)
+
+// PluginRule declares a resource and the operations handled by a plugin
+type PluginRule struct {
+	Operations []v1beta1.Operation
+	// Group of the resource, empty for the core group
+	Group string
+	// Version of the resource, empty for all versions
+	Version string
+	// Resource is the plural resource name, for example "namespaces"
+	Resource string
+	// Kind is matched case-insensitively when the request does not contain a resource
+	Kind string
+}
+
+// AdmissionPlugin is a single mutator or validator in a PluginChain
+type AdmissionPlugin interface {
+	Name() string
+	Type() PluginType
+	Rules() []PluginRule
+	// Admit returns the response for the request, or nil when the plugin has nothing to say
+	Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse
+}
+
+// RequestFinisher is implemented by plugins keeping state of a request between the plugins of a
+// chain. The chain calls Finish after all its plugins have seen the request.
+type RequestFinisher interface {
+	Finish(request *v1beta1.AdmissionRequest)
+}
+
+// SideEffectPlugin is implemented by plugins changing more than the object of the request, for
+// example by registering it with an external API. They run after the validating plugins of a chain
+// and their webhook is not reinvoked.
+type SideEffectPlugin interface {
+	HasSideEffects() bool
+}
+
+// RequestMetrics count and time the requests of a category
+type RequestMetrics struct {
+	Total    prometheus.Counter
+	Handled  prometheus.Counter
+	Errors   prometheus.Counter
+	Duration prometheus.Histogram
+}
+
+// MetricsFunc returns the metrics of the category of a request, nil when the request has no category
+type MetricsFunc func(request *v1beta1.AdmissionRequest) *RequestMetrics
+
+var (
+	requestMetrics = RequestMetrics{
+		Total: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "bhadmission_requests_total",
+			Help: "The total number of processed requests",
+		}),
+		Handled: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "bhadmission_requests_handled",
+			Help: "The total number of processed requests",
+		}),
+		Errors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "bhadmission_requests_error",
+			Help: "The total number of requests in error",
+		}),
+		Duration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bhadmission_requests_duration",
+			Help:    "The durations of all requests",
+			Buckets: prometheus.LinearBuckets(1, 3, 5),
+		}),
+	}
+	pluginDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bhadmission_plugin_duration",
+		Help:    "The durations of admission plugins",
+		Buckets: prometheus.LinearBuckets(1, 3, 5),
+	}, []string{"plugin"})
+	pluginDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bhadmission_plugin_denied",
+		Help: "The total number of requests denied by admission plugins",
+	}, []string{"plugin"})
+)
+
+// Matches is true when the rule applies to the request. Requests for subresources, for example
+// the status of a resource, are not matched.
+func (rule *PluginRule) Matches(request *v1beta1.AdmissionRequest) bool {
+	if len(request.SubResource) > 0 {
+		return false
+	}
+	operationMatches := false
+	for _, op := range rule.Operations {
+		if op == request.Operation {
+			operationMatches = true
+		}
+	}
+	if !operationMatches {
+		return false
+	}
+	if len(request.Resource.Resource) > 0 {
+		return request.Resource.Group == rule.Group &&
+			strings.EqualFold(request.Resource.Resource, rule.Resource) &&
+			(len(rule.Version) == 0 || rule.Version == request.Resource.Version)
+	}
+	return len(rule.Kind) > 0 &&
+		request.Kind.Group == rule.Group &&
+		strings.EqualFold(request.Kind.Kind, rule.Kind)
+}
+
+// Handles is true when one of the plugin rules applies to the request
+func Handles(plugin AdmissionPlugin, request *v1beta1.AdmissionRequest) bool {
+	for _, rule := range plugin.Rules() {
+		if rule.Matches(request) {
+			return true
+		}
+	}
+	return false
+}
+
+// PluginRegistry holds the available plugins by name
+type PluginRegistry struct {
+	plugins map[string]AdmissionPlugin
+	// Metrics is passed to the chains of the registry
+	Metrics MetricsFunc
+}
+
+// NewPluginRegistry creates an empty registry
+func NewPluginRegistry() *PluginRegistry {
+	return &PluginRegistry{
+		plugins: map[string]AdmissionPlugin{},
+	}
+}
+
+// Register adds a plugin to the registry
+func (registry *PluginRegistry) Register(plugin AdmissionPlugin) error {
+	if _, ok := registry.plugins[plugin.Name()]; ok {
+		return errors.New("plugin " + plugin.Name() + " is already registered")
+	}
+	registry.plugins[plugin.Name()] = plugin
+	return nil
+}
+
+// Chain creates a chain of the named plugins in the given order
+func (registry *PluginRegistry) Chain(names []string) (*PluginChain, error) {
+	plugins := []AdmissionPlugin{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		plugin, ok := registry.plugins[name]
+		if !ok {
+			return nil, errors.New("unknown plugin " + name)
+		}
+		plugins = append(plugins, plugin)
+	}
+	if len(plugins) == 0 {
+		return nil, errors.New("no plugins enabled")
+	}
+	chain := NewPluginChain(plugins...)
+	chain.Metrics = registry.Metrics
+	return chain, nil
+}
+
+// PluginChain runs its plugins in order, merges their patches and stops at the first denial.
+// Every plugin sees the original object, not the result of the patches of the plugins before it.
+type PluginChain struct {
+	Plugins []AdmissionPlugin
+	// Metrics adds the metrics of the category of a request to the metrics of all requests, none when nil
+	Metrics MetricsFunc
+}
+
+// NewPluginChain creates a chain of plugins
+func NewPluginChain(plugins ...AdmissionPlugin) *PluginChain {
+	return &PluginChain{
+		Plugins: plugins,
+	}
+}
+
+// HandleAdmission runs the plugins handling the request
+func (chain *PluginChain) HandleAdmission(review *v1beta1.AdmissionReview) error {
+	defer func() {
+		if r := recover(); r != nil {
+			log := logrus.NewEntry(logrus.StandardLogger())
+			if review.Request != nil {
+				log = logging.ForRequest(review.Request)
+			}
+			log.Error("Recovering from panic:\n", string(debug.Stack()))
+			review.Response = &v1beta1.AdmissionResponse{
+				Allowed: true,
+				Result: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: "Internal error",
+				},
+			}
+			return
+		}
+	}()
+
+	if review.Request == nil {
+		logrus.Info("EMPTY REQUEST for HandleAdmission - ignored")
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "Invalid AdmissionReview",
+			},
+		}
+		return nil
+	}
+
+	defer chain.finish(review.Request)
+
+	log := logging.ForRequest(review.Request)
+	log.Info("NEW REQUEST for HandleAdmission")
+	logging.LogReview(log, review)
+
+	var patch patchMerger
+	var result *metav1.Status
+	handled := false
+	failed := false
+	startRequestTime := time.Now()
+	defer func() {
+		if handled {
+			// a panic leaves the response empty and counts as an error
+			chain.observe(review.Request, time.Since(startRequestTime), failed || review.Response == nil)
+		}
+	}()
+	for _, plugin := range chain.Plugins {
+		if !Handles(plugin, review.Request) {
+			continue
+		}
+		handled = true
+		startPluginTime := time.Now()
+		response := plugin.Admit(review.Request)
+		pluginDuration.WithLabelValues(plugin.Name()).Observe(time.Since(startPluginTime).Seconds())
+		if response == nil {
+			continue
+		}
+		if !response.Allowed {
+			pluginDenied.WithLabelValues(plugin.Name()).Inc()
+			log.WithFields(logrus.Fields{
+				"plugin": plugin.Name(),
+			}).Info("Request denied")
+			review.Response = response
+			return nil
+		}
+		if response.Result != nil && response.Result.Status == metav1.StatusFailure {
+			// the plugin failed and allowed the request
+			failed = true
+		}
+		if len(response.Patch) > 0 {
+			if plugin.Type() != Mutating {
+				log.Errorln("Ignoring patch of validating plugin", plugin.Name())
+				failed = true
+			} else if err := patch.add(response.Patch); err != nil {
+				log.Errorln("Ignoring invalid patch of plugin "+plugin.Name()+":", err)
+				failed = true
+			}
+		}
+		if result == nil {
+			result = response.Result
+		}
+	}
+	if !handled {
+		log.Debug("Ignoring AdmissingRequest for type:", review.Request.Kind.Kind)
+		return nil
+	}
+
+	review.Response = &v1beta1.AdmissionResponse{
+		Allowed: true,
+		Result:  result,
+	}
+	if patchBytes, err := patch.bytes(); err != nil {
+		log.Errorln("Failed to merge patches:", err)
+		failed = true
+	} else if len(patchBytes) > 0 {
+		review.Response.Patch = patchBytes
+		pt := v1beta1.PatchTypeJSONPatch
+		review.Response.PatchType = &pt
+	}
+	return nil
+}
+
+// observe records a request handled by the chain in the metrics of all requests and of its category
+func (chain *PluginChain) observe(request *v1beta1.AdmissionRequest, elapsed time.Duration, failed bool) {
+	metrics := []RequestMetrics{requestMetrics}
+	if chain.Metrics != nil {
+		if category := chain.Metrics(request); category != nil {
+			metrics = append(metrics, *category)
+		}
+	}
+	for _, m := range metrics {
+		m.Total.Inc()
+		m.Duration.Observe(elapsed.Seconds())
+		if failed {
+			m.Errors.Inc()
+		} else {
+			m.Handled.Inc()
+		}
+	}
+}
+
+// HasSideEffects is true when a plugin of the chain has side effects
+func (chain *PluginChain) HasSideEffects() bool {
+	for _, plugin := range chain.Plugins {
+		if sideEffects, ok := plugin.(SideEffectPlugin); ok && sideEffects.HasSideEffects() {
+			return true
+		}
+	}
+	return false
+}
+
+// finish lets the plugins release the state of the request
+func (chain *PluginChain) finish(request *v1beta1.AdmissionRequest) {
+	for _, plugin := range chain.Plugins {
+		if finisher, ok := plugin.(RequestFinisher); ok {
+			finisher.Finish(request)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+)
+
+type testPlugin struct {
+	name     string
+	response *v1beta1.AdmissionResponse
+	called   bool
+	finished bool
+}
+
+func (p *testPlugin) Name() string {
+	return p.name
+}
+
+func (p *testPlugin) Type() PluginType {
+	return Mutating
+}
+
+func (p *testPlugin) Rules() []PluginRule {
+	return []PluginRule{{Operations: []v1beta1.Operation{v1beta1.Create}, Version: "v1", Resource: "namespaces", Kind: "Namespace"}}
+}
+
+func (p *testPlugin) Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	p.called = true
+	return p.response
+}
+
+func (p *testPlugin) Finish(request *v1beta1.AdmissionRequest) {
+	p.finished = true
+}
+
+func patched(patch string) *v1beta1.AdmissionResponse {
+	pt := v1beta1.PatchTypeJSONPatch
+	return &v1beta1.AdmissionResponse{Allowed: true, Patch: []byte(patch), PatchType: &pt}
+}
+
+func namespaceReview() *v1beta1.AdmissionReview {
+	return &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Kind: "Namespace"},
+			Operation: v1beta1.Create,
+		},
+	}
+}
+
+func TestPluginChainMergesPatches(t *testing.T) {
+	registry := NewPluginRegistry()
+	_ = registry.Register(&testPlugin{name: "first", response: patched(`[{"op":"add","path":"/metadata/annotations","value":{"a":"1"}}]`)})
+	_ = registry.Register(&testPlugin{name: "second", response: patched(`[{"op":"add","path":"/metadata/annotations","value":{"b/c":"2"}}]`)})
+	if err := registry.Register(&testPlugin{name: "first"}); err == nil {
+		t.Error("Expected error for duplicate plugin")
+	}
+	if _, err := registry.Chain([]string{"unknown"}); err == nil {
+		t.Error("Expected error for unknown plugin")
+	}
+	chain, err := registry.Chain([]string{"first", "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := namespaceReview()
+	_ = chain.HandleAdmission(review)
+	var patch []map[string]interface{}
+	if err := json.Unmarshal(review.Response.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{"op": "add", "path": "/metadata/annotations", "value": map[string]interface{}{"a": "1"}},
+		{"op": "add", "path": "/metadata/annotations/b~1c", "value": "2"},
+	}
+	if !reflect.DeepEqual(patch, expected) {
+		t.Error("Unexpected patch:", string(review.Response.Patch))
+	}
+}
+
+func TestEscapeJSONPointer(t *testing.T) {
+	if escaped := EscapeJSONPointer("a/b~c"); escaped != "a~1b~0c" {
+		t.Error("Unexpected escaped key:", escaped)
+	}
+}
+
+func TestPluginChainStopsAtDenial(t *testing.T) {
+	deny := &testPlugin{name: "deny", response: &v1beta1.AdmissionResponse{Allowed: false}}
+	mutate := &testPlugin{name: "mutate", response: patched(`[]`)}
+	review := namespaceReview()
+	_ = NewPluginChain(deny, mutate).HandleAdmission(review)
+	if review.Response == nil || review.Response.Allowed {
+		t.Error("Request was not denied")
+	}
+	if mutate.called {
+		t.Error("Plugin after the denial was called")
+	}
+	if !deny.finished || !mutate.finished {
+		t.Error("Plugins were not finished after the denial")
+	}
+
+	review = namespaceReview()
+	review.Request.Kind.Kind = "Secret"
+	_ = NewPluginChain(deny).HandleAdmission(review)
+	if review.Response != nil {
+		t.Error("Request not handled by any plugin has a response")
+	}
+
+	review = namespaceReview()
+	review.Request.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	review.Request.SubResource = "status"
+	_ = NewPluginChain(deny).HandleAdmission(review)
+	if review.Response != nil {
+		t.Error("Request for a subresource has a response")
+	}
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	if err := counter.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestPluginChainMetrics(t *testing.T) {
+	metrics := &RequestMetrics{
+		Total:    prometheus.NewCounter(prometheus.CounterOpts{Name: "total"}),
+		Handled:  prometheus.NewCounter(prometheus.CounterOpts{Name: "handled"}),
+		Errors:   prometheus.NewCounter(prometheus.CounterOpts{Name: "errors"}),
+		Duration: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration"}),
+	}
+	failure := &v1beta1.AdmissionResponse{Allowed: true, Result: &metav1.Status{Status: metav1.StatusFailure}}
+	tests := []struct {
+		name     string
+		plugins  []AdmissionPlugin
+		kind     string
+		total    float64
+		handled  float64
+		failures float64
+	}{
+		{"patched", []AdmissionPlugin{&testPlugin{name: "mutate", response: patched(`[]`)}}, "Namespace", 1, 1, 0},
+		{"denied", []AdmissionPlugin{&testPlugin{name: "deny", response: &v1beta1.AdmissionResponse{Allowed: false}}}, "Namespace", 1, 1, 0},
+		{"failed", []AdmissionPlugin{&testPlugin{name: "mutate", response: patched(`[]`)}, &testPlugin{name: "fail", response: failure}}, "Namespace", 1, 0, 1},
+		{"not handled", []AdmissionPlugin{&testPlugin{name: "mutate"}}, "Secret", 0, 0, 0},
+	}
+	for _, test := range tests {
+		total, handled, failures := counterValue(t, metrics.Total), counterValue(t, metrics.Handled), counterValue(t, metrics.Errors)
+		chain := NewPluginChain(test.plugins...)
+		chain.Metrics = func(request *v1beta1.AdmissionRequest) *RequestMetrics {
+			return metrics
+		}
+		review := namespaceReview()
+		review.Request.Kind.Kind = test.kind
+		_ = chain.HandleAdmission(review)
+		if counterValue(t, metrics.Total)-total != test.total || counterValue(t, metrics.Handled)-handled != test.handled ||
+			counterValue(t, metrics.Errors)-failures != test.failures {
+			t.Errorf("%s: unexpected metrics total %v, handled %v, errors %v", test.name,
+				counterValue(t, metrics.Total)-total, counterValue(t, metrics.Handled)-handled, counterValue(t, metrics.Errors)-failures)
+		}
+	}
+}
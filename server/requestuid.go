@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// requestUID looks up request.uid in the body of an AdmissionReview that could not be
+// decoded. The body may be truncated or have the wrong content type, the fields before
+// the UID only need to be well-formed. An empty UID means that it was not found.
+func requestUID(body []byte) types.UID {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if !enterObject(decoder) {
+		return ""
+	}
+	for key, ok := nextKey(decoder); ok; key, ok = nextKey(decoder) {
+		if key != "request" {
+			if !skipValue(decoder) {
+				return ""
+			}
+			continue
+		}
+		if !enterObject(decoder) {
+			return ""
+		}
+		for key, ok := nextKey(decoder); ok; key, ok = nextKey(decoder) {
+			if key != "uid" {
+				if !skipValue(decoder) {
+					return ""
+				}
+				continue
+			}
+			var uid string
+			if err := decoder.Decode(&uid); err != nil {
+				return ""
+			}
+			return types.UID(uid)
+		}
+		return ""
+	}
+	return ""
+}
+
+// enterObject reads the opening brace of an object
+func enterObject(decoder *json.Decoder) bool {
+	token, err := decoder.Token()
+	return err == nil && token == json.Delim('{')
+}
+
+// nextKey reads the next key of the current object, false at the end of the object
+func nextKey(decoder *json.Decoder) (string, bool) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", false
+	}
+	key, ok := token.(string)
+	return key, ok
+}
+
+// skipValue reads the value of a key that is not looked up
+func skipValue(decoder *json.Decoder) bool {
+	var value json.RawMessage
+	return decoder.Decode(&value) == nil
+}
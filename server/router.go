@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Route binds a path to a chain of plugins
+type Route struct {
+	Path  string
+	Type  PluginType
+	Chain *PluginChain
+}
+
+// Routes parses a semicolon separated list of path=plugin,plugin entries, for example
+// "/mutate/namespaces=owner-annotation;/validate/namespaces=naming-policy,quota".
+// Paths starting with /validate are validating routes and can not contain mutating plugins.
+// Validating plugins must come before the plugins with side effects, so that a denied request
+// does not reach them.
+// The path "/" handles every path without its own route.
+func (registry *PluginRegistry) Routes(spec string) ([]Route, error) {
+	routes := []Route{}
+	paths := map[string]bool{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		pathAndPlugins := strings.SplitN(entry, "=", 2)
+		if len(pathAndPlugins) != 2 {
+			return nil, errors.New("route " + entry + " must be in the form path=plugin,plugin")
+		}
+		path := strings.TrimSpace(pathAndPlugins[0])
+		if !strings.HasPrefix(path, "/") {
+			return nil, errors.New("route path " + path + " must start with /")
+		}
+		if paths[path] {
+			return nil, errors.New("duplicate route " + path)
+		}
+		paths[path] = true
+		chain, err := registry.Chain(strings.Split(pathAndPlugins[1], ","))
+		if err != nil {
+			return nil, errors.New("route " + path + ": " + err.Error())
+		}
+		sideEffects := ""
+		for _, plugin := range chain.Plugins {
+			if effects, ok := plugin.(SideEffectPlugin); ok && effects.HasSideEffects() {
+				if len(sideEffects) == 0 {
+					sideEffects = plugin.Name()
+				}
+			} else if len(sideEffects) > 0 && plugin.Type() == Validating {
+				return nil, errors.New("route " + path + ": validating plugin " + plugin.Name() + " must come before " + sideEffects)
+			}
+		}
+		routeType := Mutating
+		if strings.HasPrefix(path, "/validate") {
+			routeType = Validating
+			for _, plugin := range chain.Plugins {
+				if plugin.Type() == Mutating {
+					return nil, errors.New("route " + path + ": mutating plugin " + plugin.Name() + " can not be used in a validating route")
+				}
+			}
+		}
+		routes = append(routes, Route{
+			Path:  path,
+			Type:  routeType,
+			Chain: chain,
+		})
+	}
+	if len(routes) == 0 {
+		return nil, errors.New("no routes configured")
+	}
+	return routes, nil
+}
+
+// NewRouter creates a handler serving every route with its own plugin chain.
+// Requests larger than maxRequestBytes are rejected, zero uses DefaultMaxRequestBytes.
+func NewRouter(routes []Route, maxRequestBytes int64) http.Handler {
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		handler := newAdmissionControllerServer(route.Chain)
+		handler.MaxRequestBytes = maxRequestBytes
+		mux.Handle(route.Path, handler)
+	}
+	return mux
+}
+
+// ReloadableHandler serves requests with the latest handler, it replaces the routes of a running server
+type ReloadableHandler struct {
+	handler atomic.Value
+}
+
+// handlerValue keeps the stored type constant for atomic.Value
+type handlerValue struct {
+	http.Handler
+}
+
+// NewReloadableHandler returns a handler serving with handler until it is swapped
+func NewReloadableHandler(handler http.Handler) *ReloadableHandler {
+	reloadable := &ReloadableHandler{}
+	reloadable.Swap(handler)
+	return reloadable
+}
+
+// Swap serves new requests with handler, requests in progress complete with the previous handler
+func (reloadable *ReloadableHandler) Swap(handler http.Handler) {
+	reloadable.handler.Store(handlerValue{handler})
+}
+
+func (reloadable *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reloadable.handler.Load().(handlerValue).ServeHTTP(w, r)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	registry := NewPluginRegistry()
+	_ = registry.Register(&testPlugin{name: "mutate", response: patched(`[{"op":"add","path":"/metadata/labels","value":{"a":"1"}}]`)})
+
+	if _, err := registry.Routes("/validate/namespaces=mutate"); err == nil {
+		t.Error("Expected error for mutating plugin in validating route")
+	}
+	if _, err := registry.Routes("mutate=mutate"); err == nil {
+		t.Error("Expected error for route without leading /")
+	}
+	if _, err := registry.Routes("/a=mutate;/a=mutate"); err == nil {
+		t.Error("Expected error for duplicate route")
+	}
+
+	routes, err := registry.Routes("/mutate/namespaces=mutate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewRouter(routes, 0))
+	defer server.Close()
+
+	body, _ := json.Marshal(namespaceReview())
+	r, err := http.Post(server.URL+"/mutate/namespaces", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		t.Error("Unexpected status for configured route:", r.StatusCode)
+	}
+	r, err = http.Post(server.URL+"/mutate/accounts", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Error("Unexpected status for unknown route:", r.StatusCode)
+	}
+}
+
+func TestReloadableHandler(t *testing.T) {
+	status := func(code int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(code) })
+	}
+	handler := NewReloadableHandler(status(http.StatusOK))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Error("Unexpected status:", w.Code)
+	}
+	handler.Swap(http.NotFoundHandler())
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Error("Handler was not swapped, status:", w.Code)
+	}
+}
+
+type sideEffectPlugin struct {
+	testPlugin
+}
+
+func (p *sideEffectPlugin) Type() PluginType {
+	return Validating
+}
+
+func (p *sideEffectPlugin) HasSideEffects() bool {
+	return true
+}
+
+type validatingPlugin struct {
+	testPlugin
+}
+
+func (p *validatingPlugin) Type() PluginType {
+	return Validating
+}
+
+func TestRoutesSideEffects(t *testing.T) {
+	registry := NewPluginRegistry()
+	_ = registry.Register(&sideEffectPlugin{testPlugin{name: "register"}})
+	_ = registry.Register(&validatingPlugin{testPlugin{name: "validate"}})
+
+	if _, err := registry.Routes("/mutate=register,validate"); err == nil {
+		t.Error("Expected error for validating plugin after plugin with side effects")
+	}
+	routes, err := registry.Routes("/mutate=validate,register;/validate=validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !routes[0].Chain.HasSideEffects() || routes[1].Chain.HasSideEffects() {
+		t.Error("Unexpected side effects of the routes")
+	}
+}
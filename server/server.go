@@ -3,12 +3,17 @@ package server
 import (
 	"crypto/tls"
 	"github.com/sirupsen/logrus"
+	"io"
 	"io/ioutil"
 	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/json"
+	"mime"
+	"namespace-admission-controller/capture"
 	"net/http"
+	"strconv"
 )
 
 // AdmissionController interface
@@ -16,54 +21,175 @@ type AdmissionController interface {
 	HandleAdmission(review *v1beta1.AdmissionReview) error
 }
 
+// DefaultMaxRequestBytes limits the size of an AdmissionReview. It leaves room for the
+// object and the old object of the largest resources accepted by the API server.
+const DefaultMaxRequestBytes = 7 * 1024 * 1024
+
 // AdmissionControllerServer struct
 type AdmissionControllerServer struct {
 	AdmissionController AdmissionController
 	Decoder             runtime.Decoder
+	// MaxRequestBytes limits the request body, DefaultMaxRequestBytes when zero
+	MaxRequestBytes int64
+}
+
+// writeError writes a Status as JSON error body
+func writeError(w http.ResponseWriter, code int, message string) {
+	logrus.WithFields(logrus.Fields{
+		"code": code,
+	}).Errorln("Rejecting request:", message)
+	status := errorStatus(code, message)
+	status.TypeMeta = metav1.TypeMeta{
+		Kind:       "Status",
+		APIVersion: "v1",
+	}
+	writeJSON(w, code, status)
+}
+
+// writeRequestError writes an AdmissionReview denying the request when the UID can be read
+// from the body, so the error matches the request, and a Status otherwise
+func writeRequestError(w http.ResponseWriter, body []byte, code int, message string) {
+	uid := requestUID(body)
+	if len(uid) == 0 {
+		writeError(w, code, message)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"code": code,
+		"uid":  uid,
+	}).Errorln("Rejecting request:", message)
+	status := errorStatus(code, message)
+	writeJSON(w, code, &v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+		},
+		Response: &v1beta1.AdmissionResponse{
+			UID:     uid,
+			Allowed: false,
+			Result:  &status,
+		},
+	})
+}
+
+func errorStatus(code int, message string) metav1.Status {
+	return metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: message,
+		Code:    int32(code),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if responseInBytes, err := json.Marshal(body); err == nil {
+		_, _ = w.Write(responseInBytes)
+	}
 }
 
 func (acs *AdmissionControllerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// logrus.Debugln("ServerHTTP new request: ", r)
-	var body []byte
-	if data, err := ioutil.ReadAll(r.Body); err == nil {
-		body = data
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed")
+		return
+	}
+	maxRequestBytes := acs.MaxRequestBytes
+	if maxRequestBytes <= 0 {
+		maxRequestBytes = DefaultMaxRequestBytes
+	}
+	// read one byte more than allowed to detect requests that are too large. The body is read
+	// before the content type is checked, the UID of the request is echoed in the error.
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestBytes+1))
+	if err != nil {
+		writeRequestError(w, body, http.StatusBadRequest, "failed to read request: "+err.Error())
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		writeRequestError(w, body, http.StatusUnsupportedMediaType, "content type "+contentType+" is not supported, expected application/json")
+		return
+	}
+	if int64(len(body)) > maxRequestBytes {
+		writeRequestError(w, body, http.StatusRequestEntityTooLarge, "request is larger than "+strconv.FormatInt(maxRequestBytes, 10)+" bytes")
+		return
 	}
 	// logrus.Debugln("RequestBody: ", body)
 	review := &v1beta1.AdmissionReview{}
-	_, _, err := acs.Decoder.Decode(body, nil, review)
-	if err != nil {
-		logrus.Errorln("Can't decode request", err)
+	if _, _, err := acs.Decoder.Decode(body, nil, review); err != nil {
+		writeRequestError(w, body, http.StatusBadRequest, "can't decode request: "+err.Error())
+		return
+	}
+	if review.Request == nil {
+		writeError(w, http.StatusBadRequest, "AdmissionReview without request")
+		return
 	}
 	// logrus.Debugln("AdmissionReview: ", review)
-	// ignore errors for now
-	_ = acs.AdmissionController.HandleAdmission(review)
-	if responseInBytes, err := json.Marshal(review); err != nil {
-		logrus.Errorln("Can't marshal request", err)
-	} else {
-		if _, err := w.Write(responseInBytes); err != nil {
-			logrus.Errorln("Failed to write response", err)
+	if err := acs.AdmissionController.HandleAdmission(review); err != nil {
+		logrus.Errorln("HandleAdmission failed:", err)
+	}
+	if review.Response == nil {
+		review.Response = &v1beta1.AdmissionResponse{
+			Allowed: true,
 		}
 	}
+	// the API server matches the response to the request by UID
+	review.Response.UID = review.Request.UID
+	review.APIVersion = v1beta1.SchemeGroupVersion.String()
+	review.Kind = "AdmissionReview"
+	responseInBytes, err := json.Marshal(review)
+	if err != nil {
+		logrus.Errorln("Can't marshal request", err)
+		writeRequestError(w, body, http.StatusInternalServerError, "can't marshal response: "+err.Error())
+		return
+	}
+	capture.Record(review)
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(responseInBytes); err != nil {
+		logrus.Errorln("Failed to write response", err)
+	}
 }
 
-// GetAdmissionServerNoSSL function
-func GetAdmissionServerNoSSL(ac AdmissionController, listenOn string) *http.Server {
+func newAdmissionControllerServer(ac AdmissionController) *AdmissionControllerServer {
 	scheme := runtime.NewScheme()
 	codecs := serializer.NewCodecFactory(scheme)
+	return &AdmissionControllerServer{
+		AdmissionController: ac,
+		Decoder:             codecs.UniversalDeserializer(),
+	}
+}
+
+// GetAdmissionServerNoSSL function
+func GetAdmissionServerNoSSL(ac AdmissionController, listenOn string) *http.Server {
 	server := &http.Server{
-		Handler: &AdmissionControllerServer{
-			AdmissionController: ac,
-			Decoder:             codecs.UniversalDeserializer(),
-		},
-		Addr: listenOn,
+		Handler: newAdmissionControllerServer(ac),
+		Addr:    listenOn,
+	}
+	return server
+}
+
+// GetRouterServerNoSSL returns a server with a plugin chain for every route
+func GetRouterServerNoSSL(routes []Route, maxRequestBytes int64, listenOn string) *http.Server {
+	server := &http.Server{
+		Handler: NewRouter(routes, maxRequestBytes),
+		Addr:    listenOn,
 	}
 	return server
 }
 
 // GetAdmissionValidationServer function
 func GetAdmissionValidationServer(ac AdmissionController, tlsCert, tlsKey, listenOn string) *http.Server {
+	return withTLS(GetAdmissionServerNoSSL(ac, listenOn), tlsCert, tlsKey)
+}
+
+// GetRouterValidationServer returns a TLS server with a plugin chain for every route
+func GetRouterValidationServer(routes []Route, maxRequestBytes int64, tlsCert, tlsKey, listenOn string) *http.Server {
+	return withTLS(GetRouterServerNoSSL(routes, maxRequestBytes, listenOn), tlsCert, tlsKey)
+}
+
+func withTLS(server *http.Server, tlsCert, tlsKey string) *http.Server {
 	sCert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
-	server := GetAdmissionServerNoSSL(ac, listenOn)
 	server.TLSConfig = &tls.Config{
 		Certificates: []tls.Certificate{sCert},
 	}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	fuzz "github.com/google/gofuzz"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func post(handler http.Handler, method, contentType string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", bytes.NewReader(body))
+	if len(contentType) > 0 {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeHTTPRejectsMalformedRequests(t *testing.T) {
+	handler := newAdmissionControllerServer(NewPluginChain(&testPlugin{name: "test"}))
+	handler.MaxRequestBytes = 1024
+	valid, _ := json.Marshal(namespaceReview())
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        []byte
+		code        int
+	}{
+		{"valid", http.MethodPost, "application/json", valid, http.StatusOK},
+		{"charset", http.MethodPost, "application/json; charset=utf-8", valid, http.StatusOK},
+		{"get", http.MethodGet, "application/json", nil, http.StatusMethodNotAllowed},
+		{"no content type", http.MethodPost, "", valid, http.StatusUnsupportedMediaType},
+		{"yaml", http.MethodPost, "application/yaml", valid, http.StatusUnsupportedMediaType},
+		{"too large", http.MethodPost, "application/json", bytes.Repeat([]byte(" "), 1025), http.StatusRequestEntityTooLarge},
+		{"not json", http.MethodPost, "application/json", []byte("{"), http.StatusBadRequest},
+		{"no request", http.MethodPost, "application/json", []byte(`{"kind":"AdmissionReview"}`), http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := post(handler, test.method, test.contentType, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.code, w.Code)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") || !json.Valid(w.Body.Bytes()) {
+			t.Errorf("%s: response is not JSON: %s", test.name, w.Body.String())
+		}
+	}
+}
+
+func TestServeHTTPEchoesUIDOnErrors(t *testing.T) {
+	handler := newAdmissionControllerServer(NewPluginChain(&testPlugin{name: "test"}))
+	handler.MaxRequestBytes = 1024
+	review := namespaceReview()
+	review.Request.UID = "e911857d-c318-11e8-bbad-025000000001"
+	valid, _ := json.Marshal(review)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		code        int
+		uid         string
+	}{
+		{"yaml", "application/yaml", valid, http.StatusUnsupportedMediaType, "e911857d-c318-11e8-bbad-025000000001"},
+		{"too large", "application/json", append([]byte(`{"request":{"uid":"u-1","object":"`), bytes.Repeat([]byte("a"), 1024)...), http.StatusRequestEntityTooLarge, "u-1"},
+		{"invalid field", "application/json", []byte(`{"kind":"AdmissionReview","request":{"operation":1,"uid":"u-2"}}`), http.StatusBadRequest, "u-2"},
+		{"truncated before the UID", "application/json", []byte(`{"request":{"kind":{"kind":"Namespace"`), http.StatusBadRequest, ""},
+		{"no request", "application/json", []byte(`{"kind":"AdmissionReview","response":{"uid":"u-3"}}`), http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		w := post(handler, http.MethodPost, test.contentType, test.body)
+		if w.Code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.code, w.Code)
+		}
+		if len(test.uid) == 0 {
+			var status metav1.Status
+			if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.Kind != "Status" || status.Code != int32(test.code) {
+				t.Errorf("%s: expected a Status, got %s", test.name, w.Body.String())
+			}
+			continue
+		}
+		var response v1beta1.AdmissionReview
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Kind != "AdmissionReview" || response.Response == nil {
+			t.Errorf("%s: expected an AdmissionReview, got %s", test.name, w.Body.String())
+			continue
+		}
+		if string(response.Response.UID) != test.uid || response.Response.Allowed ||
+			response.Response.Result == nil || response.Response.Result.Code != int32(test.code) {
+			t.Errorf("%s: expected a denial of %s, got %s", test.name, test.uid, w.Body.String())
+		}
+	}
+}
+
+// checkResponse verifies that the handler answers with an error or with a well-formed AdmissionReview
+func checkResponse(t *testing.T, name string, w *httptest.ResponseRecorder) {
+	if w.Code == http.StatusBadRequest {
+		return
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("%s: unexpected status %d: %s", name, w.Code, w.Body.String())
+		return
+	}
+	var review v1beta1.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+		t.Errorf("%s: response is not an AdmissionReview: %v", name, err)
+		return
+	}
+	if review.Kind != "AdmissionReview" || review.APIVersion != "admission.k8s.io/v1beta1" {
+		t.Errorf("%s: unexpected type %s %s", name, review.APIVersion, review.Kind)
+	}
+	if review.Request == nil || review.Response == nil || review.Response.UID != review.Request.UID {
+		t.Errorf("%s: response UID does not match request UID: %s", name, w.Body.String())
+	}
+}
+
+// TestServeHTTPFuzz feeds the corpus in testdata/fuzz, random mutations of it and
+// randomly generated AdmissionReviews through the decoder path
+func TestServeHTTPFuzz(t *testing.T) {
+	handler := newAdmissionControllerServer(NewPluginChain(&testPlugin{
+		name:     "test",
+		response: patched(`[{"op":"add","path":"/metadata/labels","value":{"a":"1"}}]`),
+	}))
+	corpus, err := filepath.Glob(filepath.Join("testdata", "fuzz", "*.json"))
+	if err != nil || len(corpus) == 0 {
+		t.Fatal("no fuzz corpus found", err)
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, file := range corpus {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponse(t, file, post(handler, http.MethodPost, "application/json", body))
+		for i := 0; i < 200 && len(body) > 0; i++ {
+			mutated := append([]byte{}, body...)
+			switch i % 3 {
+			case 0:
+				mutated[random.Intn(len(mutated))] = byte(random.Intn(256))
+			case 1:
+				mutated = mutated[:random.Intn(len(mutated))]
+			case 2:
+				pos := random.Intn(len(mutated))
+				mutated = append(mutated[:pos], append([]byte(`{"":[null,1.5e999,"\u0000"]}`), mutated[pos:]...)...)
+			}
+			checkResponse(t, file, post(handler, http.MethodPost, "application/json", mutated))
+		}
+	}
+
+	f := fuzz.New().NilChance(0.2).NumElements(0, 3).RandSource(random).Funcs(
+		func(e *runtime.RawExtension, c fuzz.Continue) {
+			// the object is opaque JSON, the decoder must not look into it
+			objects := []string{``, `null`, `{}`, `{"metadata":{"name":"` + c.RandString() + `"}}`, `[1,2]`}
+			e.Raw = []byte(objects[c.Intn(len(objects))])
+		},
+	)
+	for i := 0; i < 500; i++ {
+		review := &v1beta1.AdmissionReview{}
+		f.Fuzz(review)
+		body, err := json.Marshal(review)
+		if err != nil {
+			continue
+		}
+		checkResponse(t, "generated review", post(handler, http.MethodPost, "application/json", body))
+	}
+}
+
+// BenchmarkServeHTTP measures decoding the review, running a chain with a patch and encoding the response
+func BenchmarkServeHTTP(b *testing.B) {
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+	logrus.SetOutput(ioutil.Discard)
+	handler := newAdmissionControllerServer(NewPluginChain(&testPlugin{
+		name:     "test",
+		response: patched(`[{"op":"add","path":"/metadata/labels","value":{"a":"1"}}]`),
+	}))
+	review := namespaceReview()
+	review.Request.UID = "e911857d-c318-11e8-bbad-025000000001"
+	review.Request.Object.Raw = []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"test","labels":{"app":"test"}}}`)
+	body, _ := json.Marshal(review)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if w := post(handler, http.MethodPost, "application/json", body); w.Code != http.StatusOK {
+			b.Fatal(w.Code, w.Body.String())
+		}
+	}
+}
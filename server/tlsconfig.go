@@ -0,0 +1,240 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// TLSOptions configures the TLS listener of the webhook. Empty values keep the Go defaults.
+type TLSOptions struct {
+	// MinVersion and MaxVersion are "1.0" to "1.3" or the OpenShift names "VersionTLS10" to "VersionTLS13"
+	MinVersion string
+	MaxVersion string
+	// CipherSuites are IANA names, for example TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+	// They only apply to TLS 1.2 and lower, TLS 1.3 suites are accepted and ignored.
+	CipherSuites []string
+	// CurvePreferences are X25519, P256, P384 and P521
+	CurvePreferences []string
+	HTTP2            bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0":          tls.VersionTLS10,
+	"1.1":          tls.VersionTLS11,
+	"1.2":          tls.VersionTLS12,
+	"1.3":          tls.VersionTLS13,
+	"VersionTLS10": tls.VersionTLS10,
+	"VersionTLS11": tls.VersionTLS11,
+	"VersionTLS12": tls.VersionTLS12,
+	"VersionTLS13": tls.VersionTLS13,
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "1.0",
+	tls.VersionTLS11: "1.1",
+	tls.VersionTLS12: "1.2",
+	tls.VersionTLS13: "1.3",
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+func parseTLSVersion(version string, defaultVersion uint16) (uint16, error) {
+	if len(version) == 0 {
+		return defaultVersion, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.New("unknown TLS version " + version)
+	}
+	return v, nil
+}
+
+// cipherSuite returns the secure cipher suite with the IANA name
+func cipherSuite(name string) (*tls.CipherSuite, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return nil, errors.New("cipher suite " + name + " is insecure")
+		}
+	}
+	return nil, errors.New("unknown cipher suite " + name)
+}
+
+// TLSConfig validates the options and returns the matching configuration
+func (options *TLSOptions) TLSConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(options.MinVersion, tls.VersionTLS12)
+	if err != nil {
+		return nil, err
+	}
+	maxVersion, err := parseTLSVersion(options.MaxVersion, tls.VersionTLS13)
+	if err != nil {
+		return nil, err
+	}
+	if minVersion > maxVersion {
+		return nil, errors.New("TLS minimum version " + tlsVersionNames[minVersion] + " is higher than maximum version " + tlsVersionNames[maxVersion])
+	}
+	config := &tls.Config{
+		MinVersion: minVersion,
+		MaxVersion: maxVersion,
+	}
+
+	http2Suite := false
+	configuredSuites := 0
+	for _, name := range options.CipherSuites {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		configuredSuites++
+		suite, err := cipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			// TLS 1.3 suites are not configurable
+			continue
+		}
+		config.CipherSuites = append(config.CipherSuites, suite.ID)
+		if suite.ID == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suite.ID == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			http2Suite = true
+		}
+	}
+	if configuredSuites > 0 && len(config.CipherSuites) == 0 && minVersion < tls.VersionTLS13 {
+		return nil, errors.New("no cipher suite for TLS " + tlsVersionNames[minVersion] + " configured")
+	}
+	if options.HTTP2 && len(config.CipherSuites) > 0 && !http2Suite && minVersion < tls.VersionTLS13 {
+		return nil, errors.New("HTTP/2 requires the cipher suite TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+	}
+
+	for _, name := range options.CurvePreferences {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		curve, ok := curves[name]
+		if !ok {
+			return nil, errors.New("unknown curve " + name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+	return config, nil
+}
+
+// ConfigureTLS applies the TLS options to the server and logs the effective TLS profile
+func ConfigureTLS(server *http.Server, options TLSOptions) error {
+	config, err := options.TLSConfig()
+	if err != nil {
+		return err
+	}
+	if server.TLSConfig != nil {
+		config.Certificates = server.TLSConfig.Certificates
+		config.GetCertificate = server.TLSConfig.GetCertificate
+		config.ClientCAs = server.TLSConfig.ClientCAs
+		config.ClientAuth = server.TLSConfig.ClientAuth
+	}
+	server.TLSConfig = config
+	if !options.HTTP2 {
+		// a non-nil empty map disables HTTP/2
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	logTLSProfile(config, options.HTTP2)
+	return nil
+}
+
+// TLSReloader replaces the TLS profile of a running listener. New connections use the
+// latest profile, certificates and client authentication are taken from the server.
+type TLSReloader struct {
+	server *http.Server
+	http2  bool
+	config atomic.Value
+}
+
+// ConfigureDynamicTLS applies the TLS options to the server and returns a reloader to change them later.
+// HTTP/2 can only be enabled or disabled at startup.
+func ConfigureDynamicTLS(server *http.Server, options TLSOptions) (*TLSReloader, error) {
+	if err := ConfigureTLS(server, options); err != nil {
+		return nil, err
+	}
+	reloader := &TLSReloader{
+		server: server,
+		http2:  options.HTTP2,
+	}
+	reloader.config.Store(server.TLSConfig.Clone())
+	server.TLSConfig.GetConfigForClient = reloader.getConfigForClient
+	return reloader, nil
+}
+
+// Update validates the options and uses them for new connections.
+// The current profile is kept when the options are invalid.
+func (reloader *TLSReloader) Update(options TLSOptions) error {
+	options.HTTP2 = reloader.http2
+	config, err := options.TLSConfig()
+	if err != nil {
+		return err
+	}
+	reloader.config.Store(config)
+	logTLSProfile(config, reloader.http2)
+	return nil
+}
+
+func (reloader *TLSReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	current := reloader.config.Load().(*tls.Config)
+	base := reloader.server.TLSConfig
+	config := &tls.Config{
+		MinVersion:       current.MinVersion,
+		MaxVersion:       current.MaxVersion,
+		CipherSuites:     current.CipherSuites,
+		CurvePreferences: current.CurvePreferences,
+		Certificates:     base.Certificates,
+		GetCertificate:   base.GetCertificate,
+		ClientCAs:        base.ClientCAs,
+		ClientAuth:       base.ClientAuth,
+		NextProtos:       []string{"http/1.1"},
+	}
+	if reloader.http2 {
+		// ServeTLS only adds h2 to its own copy of the server configuration
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return config, nil
+}
+
+func logTLSProfile(config *tls.Config, http2 bool) {
+	ciphers := []string{}
+	for _, id := range config.CipherSuites {
+		ciphers = append(ciphers, tls.CipherSuiteName(id))
+	}
+	curveNames := []string{}
+	for _, curve := range config.CurvePreferences {
+		for name, id := range curves {
+			if id == curve {
+				curveNames = append(curveNames, name)
+			}
+		}
+	}
+	if len(ciphers) == 0 {
+		ciphers = append(ciphers, "default")
+	}
+	if len(curveNames) == 0 {
+		curveNames = append(curveNames, "default")
+	}
+	logrus.WithFields(logrus.Fields{
+		"MinVersion":       tlsVersionNames[config.MinVersion],
+		"MaxVersion":       tlsVersionNames[config.MaxVersion],
+		"CipherSuites":     strings.Join(ciphers, ","),
+		"CurvePreferences": strings.Join(curveNames, ","),
+		"HTTP2":            http2,
+	}).Info("TLS profile")
+}
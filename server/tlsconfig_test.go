@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestTLSOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options TLSOptions
+		valid   bool
+	}{
+		{"defaults", TLSOptions{CipherSuites: []string{""}, HTTP2: true}, true},
+		{"openshift names", TLSOptions{MinVersion: "VersionTLS12", MaxVersion: "VersionTLS13"}, true},
+		{"tls 1.3 only", TLSOptions{MinVersion: "1.3", CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}}, true},
+		{"approved ciphers", TLSOptions{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_AES_128_GCM_SHA256"}, CurvePreferences: []string{"X25519", "P256"}, HTTP2: true}, true},
+		{"unknown version", TLSOptions{MinVersion: "1.4"}, false},
+		{"min above max", TLSOptions{MinVersion: "1.3", MaxVersion: "1.2"}, false},
+		{"insecure cipher", TLSOptions{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, false},
+		{"unknown cipher", TLSOptions{CipherSuites: []string{"DES-CBC3-SHA"}}, false},
+		{"only tls 1.3 ciphers for tls 1.2", TLSOptions{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}}, false},
+		{"http2 without required cipher", TLSOptions{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, HTTP2: true}, false},
+		{"unknown curve", TLSOptions{CurvePreferences: []string{"P224"}}, false},
+	}
+	for _, test := range tests {
+		_, err := test.options.TLSConfig()
+		if test.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestConfigureTLS(t *testing.T) {
+	s := &http.Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{{}}}}
+	err := ConfigureTLS(s, TLSOptions{
+		MinVersion:   "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TLSConfig.MinVersion != tls.VersionTLS12 || len(s.TLSConfig.CipherSuites) != 1 || len(s.TLSConfig.Certificates) != 1 {
+		t.Error("Unexpected TLS configuration:", s.TLSConfig)
+	}
+	if s.TLSNextProto == nil {
+		t.Error("HTTP/2 was not disabled")
+	}
+}
+
+func TestTLSReloader(t *testing.T) {
+	s := &http.Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{{}}}}
+	reloader, err := ConfigureDynamicTLS(s, TLSOptions{MinVersion: "1.2", HTTP2: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Update(TLSOptions{MinVersion: "1.3"}); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := s.TLSConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if config.MinVersion != tls.VersionTLS13 || len(config.Certificates) != 1 || config.NextProtos[0] != "h2" {
+		t.Error("Unexpected TLS configuration after update:", config)
+	}
+	if err := reloader.Update(TLSOptions{MinVersion: "1.4"}); err == nil {
+		t.Error("Expected error for invalid options")
+	}
+	config, _ = s.TLSConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if config.MinVersion != tls.VersionTLS13 {
+		t.Error("Invalid options replaced the TLS profile")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"namespace-admission-controller/server"
 	"namespace-admission-controller/webhook"
+	"namespace-admission-controller/webhook/webhooktest"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -73,3 +74,134 @@ func TestServeReturnsCorrectJson(t *testing.T) {
 		t.Error("Request and response UID don't match")
 	}
 }
+
+func TestExternalAPIGateDeniesNamespace(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	externalAPI.Respond(http.StatusOK, `{"allowed": false, "reason": "project was not pre-approved"}`)
+
+	nsc := &webhook.BhAdmission{
+		ExternalAPIURL:     externalAPI.URL(),
+		ExternalAPITimeout: 5,
+		ExternalAPIMode:    webhook.ExternalAPIModeGate,
+		Clients:            webhooktest.NewCluster().Clients(),
+	}
+	server := httptest.NewServer(server.GetAdmissionServerNoSSL(nsc, ":8080").Handler)
+	defer server.Close()
+	request := admissionRequestNS.DeepCopy()
+	request.Request.Object.Raw = []byte(`{"metadata": {"name": "test"}}`)
+	r, err := http.Post(server.URL, "application/json", strings.NewReader(string(encodeRequest(request))))
+	if err != nil {
+		t.Error("Post failed")
+		return
+	}
+	defer r.Body.Close()
+	review := decodeResponse(r.Body)
+
+	if review.Response == nil || review.Response.Allowed {
+		t.Fatal("Request was not denied by the external API gate")
+	}
+	if review.Response.Result.Message != "project was not pre-approved" {
+		t.Error("Unexpected denial reason:", review.Response.Result.Message)
+	}
+	if calls := externalAPI.Take(); len(calls) != 1 {
+		t.Error("expected a single external API call, got", len(calls))
+	}
+}
+
+func TestExternalAPIGateFailurePolicy(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	externalAPI.Respond(http.StatusServiceUnavailable, "")
+
+	for policy, allowed := range map[string]bool{
+		webhook.FailurePolicyIgnore: true,
+		webhook.FailurePolicyFail:   false,
+	} {
+		nsc := &webhook.BhAdmission{
+			ExternalAPIURL:           externalAPI.URL(),
+			ExternalAPITimeout:       5,
+			ExternalAPIMode:          webhook.ExternalAPIModeGate,
+			ExternalAPIFailurePolicy: policy,
+			Clients:                  webhooktest.NewCluster().Clients(),
+		}
+		request := admissionRequestNS.DeepCopy()
+		request.Request.Object.Raw = []byte(`{"metadata": {"name": "test"}}`)
+		_ = nsc.HandleAdmission(request)
+		if request.Response == nil || request.Response.Allowed != allowed {
+			t.Errorf("failure policy %s: expected allowed=%v", policy, allowed)
+		}
+	}
+}
+
+func TestExternalAPIGateDenialStatus(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+
+	tests := []struct {
+		status  int
+		body    string
+		allowed bool
+		message string
+	}{
+		// a denial with an error status is a decision, not a failure of the external API
+		{http.StatusForbidden, `{"allowed": false, "reason": "project was not pre-approved"}`, false, "project was not pre-approved"},
+		{http.StatusInternalServerError, `{"error": "database unavailable"}`, true, ""},
+		{http.StatusBadGateway, "<html>bad gateway</html>", true, ""},
+	}
+	for _, test := range tests {
+		externalAPI.Respond(test.status, test.body)
+		nsc := &webhook.BhAdmission{
+			ExternalAPIURL:           externalAPI.URL(),
+			ExternalAPITimeout:       5,
+			ExternalAPIMode:          webhook.ExternalAPIModeGate,
+			ExternalAPIFailurePolicy: webhook.FailurePolicyIgnore,
+			Clients:                  webhooktest.NewCluster().Clients(),
+		}
+		request := admissionRequestNS.DeepCopy()
+		request.Request.Object.Raw = []byte(`{"metadata": {"name": "test"}}`)
+		_ = nsc.HandleAdmission(request)
+		if request.Response == nil || request.Response.Allowed != test.allowed {
+			t.Errorf("HTTP %d: expected allowed=%v, got %+v", test.status, test.allowed, request.Response)
+			continue
+		}
+		if !test.allowed && request.Response.Result.Message != test.message {
+			t.Errorf("HTTP %d: unexpected denial reason %q", test.status, request.Response.Result.Message)
+		}
+	}
+}
+
+func TestExternalAPIDryRun(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+
+	dryRun := true
+	for mode, allowed := range map[string]bool{
+		webhook.ExternalAPIModeNotify: true,
+		webhook.ExternalAPIModeGate:   false,
+	} {
+		nsc := &webhook.BhAdmission{
+			ExternalAPIURL:     externalAPI.URL(),
+			ExternalAPITimeout: 5,
+			ExternalAPIMode:    mode,
+			Clients:            webhooktest.NewCluster().Clients(),
+		}
+		request := &v1beta1.AdmissionReview{Request: &v1beta1.AdmissionRequest{
+			UID:       "e911857d-c318-11e8-bbad-025000000002",
+			Kind:      v1.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"},
+			Resource:  v1.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"},
+			Name:      "builder",
+			Namespace: "test",
+			Operation: v1beta1.Create,
+			DryRun:    &dryRun,
+			Object:    runtime.RawExtension{Raw: []byte(`{"metadata": {"name": "builder", "namespace": "test"}}`)},
+		}}
+		_ = nsc.HandleAdmission(request)
+		if request.Response == nil || request.Response.Allowed != allowed {
+			t.Errorf("mode %s: expected allowed=%v, got %+v", mode, allowed, request.Response)
+		}
+		if calls := externalAPI.Take(); len(calls) > 0 {
+			t.Errorf("mode %s: dry-run called the external API %q", mode, calls)
+		}
+	}
+}
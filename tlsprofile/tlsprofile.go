@@ -0,0 +1,196 @@
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"namespace-admission-controller/server"
+	"reflect"
+	"strings"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configv1client "github.com/openshift/client-go/config/clientset/versioned/typed/config/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// APIServerName is the name of the cluster wide APIServer configuration
+const APIServerName = "cluster"
+
+// retryInterval is the wait after a failed get or watch of the APIServer configuration
+const retryInterval = 30 * time.Second
+
+// openSSLCiphers maps the OpenSSL cipher names used by the OpenShift profiles to the Go cipher suites
+var openSSLCiphers = map[string]uint16{
+	"ECDHE-ECDSA-AES128-GCM-SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-RSA-AES128-GCM-SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-RSA-AES256-GCM-SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-ECDSA-CHACHA20-POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"ECDHE-RSA-CHACHA20-POLY1305":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"ECDHE-ECDSA-AES128-SHA256":     tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-RSA-AES128-SHA256":       tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-ECDSA-AES128-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-RSA-AES128-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-ECDSA-AES256-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-RSA-AES256-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"AES128-GCM-SHA256":             tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"AES256-GCM-SHA384":             tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"AES128-SHA256":                 tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"AES128-SHA":                    tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"AES256-SHA":                    tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"DES-CBC3-SHA":                  tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_AES_128_GCM_SHA256":        tls.TLS_AES_128_GCM_SHA256,
+	"TLS_AES_256_GCM_SHA384":        tls.TLS_AES_256_GCM_SHA384,
+	"TLS_CHACHA20_POLY1305_SHA256":  tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+// secureCipher returns whether Go treats the cipher suite as secure
+func secureCipher(id uint16) bool {
+	for _, suite := range tls.CipherSuites() {
+		if suite.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// ProfileSpec returns the ciphers and minimum version of the profile.
+// A missing profile is the OpenShift default Intermediate profile.
+func ProfileSpec(profile *configv1.TLSSecurityProfile) (*configv1.TLSProfileSpec, error) {
+	if profile == nil || len(profile.Type) == 0 {
+		return configv1.TLSProfiles[configv1.TLSProfileIntermediateType], nil
+	}
+	if profile.Type == configv1.TLSProfileCustomType {
+		if profile.Custom == nil {
+			return nil, errors.New("custom TLS security profile without parameters")
+		}
+		return &profile.Custom.TLSProfileSpec, nil
+	}
+	spec, ok := configv1.TLSProfiles[profile.Type]
+	if !ok {
+		return nil, errors.New("unknown TLS security profile " + string(profile.Type))
+	}
+	return spec, nil
+}
+
+// Options converts the profile into TLS options for the webhook listener.
+// Ciphers that Go does not support or considers insecure are left out, as the profiles allow.
+// A profile whose ciphers are all left out is an error, the listener would use the default
+// suites of Go instead.
+func Options(profile *configv1.TLSSecurityProfile, http2 bool) (server.TLSOptions, error) {
+	spec, err := ProfileSpec(profile)
+	if err != nil {
+		return server.TLSOptions{}, err
+	}
+	options := server.TLSOptions{
+		MinVersion: string(spec.MinTLSVersion),
+		HTTP2:      http2,
+	}
+	for _, name := range spec.Ciphers {
+		id, ok := openSSLCiphers[name]
+		if !ok || !secureCipher(id) {
+			logrus.Debugln("Skipping unsupported cipher of TLS security profile:", name)
+			continue
+		}
+		options.CipherSuites = append(options.CipherSuites, tls.CipherSuiteName(id))
+	}
+	if len(spec.Ciphers) > 0 && len(options.CipherSuites) == 0 {
+		return server.TLSOptions{}, errors.New("no cipher of the TLS security profile is supported: " + strings.Join(spec.Ciphers, ","))
+	}
+	return options, nil
+}
+
+// Watcher applies the TLS security profile of the APIServer configuration to the webhook listener
+type Watcher struct {
+	Client   configv1client.APIServersGetter
+	Reloader *server.TLSReloader
+	HTTP2    bool
+	current  *server.TLSOptions
+}
+
+// Current reads the APIServer configuration and returns the TLS options of its profile.
+// The options are expected to be used for the listener and are not applied again by Run.
+func (watcher *Watcher) Current() (server.TLSOptions, error) {
+	apiServer, err := watcher.Client.APIServers().Get(APIServerName, metav1.GetOptions{})
+	if err != nil {
+		return server.TLSOptions{}, err
+	}
+	options, err := Options(apiServer.Spec.TLSSecurityProfile, watcher.HTTP2)
+	if err != nil {
+		return server.TLSOptions{}, err
+	}
+	watcher.current = &options
+	return options, nil
+}
+
+// apply updates the listener when the profile changed
+func (watcher *Watcher) apply(profile *configv1.TLSSecurityProfile) {
+	options, err := Options(profile, watcher.HTTP2)
+	if err == nil && watcher.current != nil && reflect.DeepEqual(options, *watcher.current) {
+		return
+	}
+	if err == nil {
+		err = watcher.Reloader.Update(options)
+	}
+	if err != nil {
+		logrus.Errorln("Keeping TLS profile, invalid APIServer tlsSecurityProfile:", err)
+		return
+	}
+	watcher.current = &options
+	logrus.Infoln("Applied APIServer TLS security profile")
+}
+
+// Run watches the APIServer configuration until stop is closed
+func (watcher *Watcher) Run(stop <-chan struct{}) {
+	for {
+		if err := watcher.watch(stop); err != nil {
+			logrus.Errorln("Failed to watch APIServer configuration:", err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// watch applies the current profile and follows changes until the watch ends
+func (watcher *Watcher) watch(stop <-chan struct{}) error {
+	apiServer, err := watcher.Client.APIServers().Get(APIServerName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	watcher.apply(apiServer.Spec.TLSSecurityProfile)
+	w, err := watcher.Client.APIServers().Watch(metav1.ListOptions{
+		FieldSelector:   "metadata.name=" + APIServerName,
+		ResourceVersion: apiServer.ResourceVersion,
+	})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				// the API server closes watches regularly
+				return nil
+			}
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+			if apiServer, ok := event.Object.(*configv1.APIServer); ok {
+				watcher.apply(apiServer.Spec.TLSSecurityProfile)
+			}
+		}
+	}
+}
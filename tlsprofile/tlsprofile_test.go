@@ -0,0 +1,94 @@
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configfake "github.com/openshift/client-go/config/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"namespace-admission-controller/server"
+	"net/http"
+)
+
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		profile    *configv1.TLSSecurityProfile
+		minVersion string
+		valid      bool
+	}{
+		{"default", nil, "VersionTLS12", true},
+		{"old", &configv1.TLSSecurityProfile{Type: configv1.TLSProfileOldType}, "VersionTLS10", true},
+		{"intermediate", &configv1.TLSSecurityProfile{Type: configv1.TLSProfileIntermediateType}, "VersionTLS12", true},
+		{"modern", &configv1.TLSSecurityProfile{Type: configv1.TLSProfileModernType}, "VersionTLS13", true},
+		{"custom", &configv1.TLSSecurityProfile{
+			Type: configv1.TLSProfileCustomType,
+			Custom: &configv1.CustomTLSProfile{TLSProfileSpec: configv1.TLSProfileSpec{
+				Ciphers:       []string{"ECDHE-RSA-AES128-GCM-SHA256", "ECDHE-RSA-AES256-GCM-SHA384"},
+				MinTLSVersion: configv1.VersionTLS12,
+			}},
+		}, "VersionTLS12", true},
+		{"custom without supported ciphers", &configv1.TLSSecurityProfile{
+			Type: configv1.TLSProfileCustomType,
+			Custom: &configv1.CustomTLSProfile{TLSProfileSpec: configv1.TLSProfileSpec{
+				Ciphers:       []string{"ECDHE-RSA-AES128-SHA256", "ECDHE-RSA-RC4-SHA"},
+				MinTLSVersion: configv1.VersionTLS12,
+			}},
+		}, "", false},
+		{"custom without parameters", &configv1.TLSSecurityProfile{Type: configv1.TLSProfileCustomType}, "", false},
+		{"unknown", &configv1.TLSSecurityProfile{Type: "Paranoid"}, "", false},
+	}
+	for _, test := range tests {
+		options, err := Options(test.profile, true)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if options.MinVersion != test.minVersion {
+			t.Errorf("%s: expected minimum version %s, got %s", test.name, test.minVersion, options.MinVersion)
+		}
+		if _, err := options.TLSConfig(); err != nil {
+			t.Errorf("%s: invalid TLS options %v", test.name, err)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	apiServer := &configv1.APIServer{ObjectMeta: metav1.ObjectMeta{Name: APIServerName}}
+	client := configfake.NewSimpleClientset(apiServer)
+	s := &http.Server{TLSConfig: &tls.Config{}}
+	watcher := &Watcher{Client: client.ConfigV1(), HTTP2: true}
+	options, err := watcher.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher.Reloader, err = server.ConfigureDynamicTLS(s, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go watcher.Run(stop)
+
+	apiServer.Spec.TLSSecurityProfile = &configv1.TLSSecurityProfile{Type: configv1.TLSProfileModernType}
+	// Run applies the profile whether the update happens before or after the watch starts
+	if _, err := client.ConfigV1().APIServers().Update(apiServer); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		config, _ := s.TLSConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+		if config.MinVersion == tls.VersionTLS13 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("Modern profile was not applied")
+}
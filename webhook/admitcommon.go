@@ -3,41 +3,224 @@ package webhook
 import (
 	"encoding/json"
 	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// ExternalAPIModeNotify registers the resource with the external API and always allows the request
+	ExternalAPIModeNotify = "notify"
+	// ExternalAPIModeGate lets the external API allow or deny the request
+	ExternalAPIModeGate = "gate"
+	// FailurePolicyIgnore allows the request when the external API fails in gate mode, or the quota
+	// can not be counted
+	FailurePolicyIgnore = "Ignore"
+	// FailurePolicyFail denies the request when the external API fails in gate mode, or the quota
+	// can not be counted
+	FailurePolicyFail = "Fail"
+
+	// ownerAnnotationKey holds the owner of a project, namespace or account
+	ownerAnnotationKey = "bnhp.cloudia/owner"
+)
+
+// admissionSubject is the object of an admission request as seen by the plugins
+type admissionSubject struct {
+	name           string
+	requester      string
+	identifierType string
+	identifier     string
+	// register is true when the subject is sent to the external API
+	register bool
+	// annotations and labels of the requested object
+	annotations map[string]string
+	labels      map[string]string
+	// annotations and labels added to the object
+	newAnnotations map[string]string
+	newLabels      map[string]string
+}
+
+// category returns the metrics category of the request, or an empty string when it is not handled
+func (bhAdmission *BhAdmission) category(request *v1beta1.AdmissionRequest) string {
+	requestKind := request.Kind.Kind
+	if request.Operation == v1beta1.Create &&
+		(strings.EqualFold("Namespace", requestKind) ||
+			strings.EqualFold("Project", requestKind)) {
+		return prefixNamespace
+	} else if request.Operation == v1beta1.Create &&
+		(strings.EqualFold("User", requestKind) ||
+			strings.EqualFold("ServiceAccount", requestKind)) {
+		return prefixAccount
+	} else if bhAdmission.resourceRule(request) != nil {
+		return prefixResource
+	}
+	return ""
+}
+
+// subjectCache shares the subject of a request between the plugins of a chain, so that the object is
+// unmarshalled and looked up once per request instead of once per plugin
+type subjectCache struct {
+	mutex    sync.Mutex
+	subjects map[types.UID]cachedSubject
+}
+
+// cachedSubject keeps the request it was resolved for, a request replayed with the same UID is resolved again
+type cachedSubject struct {
+	request *v1beta1.AdmissionRequest
+	subject *admissionSubject
+	err     error
+}
+
+func (cache *subjectCache) get(request *v1beta1.AdmissionRequest) (cachedSubject, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cached, ok := cache.subjects[request.UID]
+	return cached, ok && cached.request == request
+}
+
+func (cache *subjectCache) put(cached cachedSubject) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.subjects == nil {
+		cache.subjects = map[types.UID]cachedSubject{}
+	}
+	cache.subjects[cached.request.UID] = cached
+}
+
+// forget removes the subject of a finished request
+func (cache *subjectCache) forget(request *v1beta1.AdmissionRequest) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cached, ok := cache.subjects[request.UID]; ok && cached.request == request {
+		delete(cache.subjects, request.UID)
+	}
+}
+
+// subject resolves the object of the request once for all plugins. A nil subject means that the
+// request is ignored.
+func (bhAdmission *BhAdmission) subject(request *v1beta1.AdmissionRequest) (*admissionSubject, error) {
+	if cached, ok := bhAdmission.subjects.get(request); ok {
+		return cached.subject, cached.err
+	}
+	subject, err := bhAdmission.resolveSubject(request)
+	bhAdmission.subjects.put(cachedSubject{request: request, subject: subject, err: err})
+	return subject, err
+}
+
+func (bhAdmission *BhAdmission) resolveSubject(request *v1beta1.AdmissionRequest) (*admissionSubject, error) {
+	switch bhAdmission.category(request) {
+	case prefixNamespace:
+		return bhAdmission.namespaceSubject(request)
+	case prefixAccount:
+		return bhAdmission.accountSubject(request)
+	case prefixResource:
+		return bhAdmission.resourceSubject(request, bhAdmission.resourceRule(request))
+	}
+	return nil, nil
+}
+
 type externalValues struct {
 	// Kind        string
 	// Namespace   string
 	// AccountName string
-	EnvName     string `json:"envName"`
-	ClusterName string `json:"clusterName"`
+	EnvName     string            `json:"envName"`
+	ClusterName string            `json:"clusterName"`
+	Type        string            `json:"type,omitempty"`
+	Identifier  string            `json:"identifier,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 }
 
-func prepareAndInvokeExternal(externalAPIURL string, externalAPITimeout int32, identifier string, accountName string, clusterName string) error {
+func (bhAdmission *BhAdmission) prepareAndInvokeExternal(log *logrus.Entry, identifierType string, identifier string, labels map[string]string) (*externalDecision, error) {
 	var err error
-	if len(externalAPIURL) > 0 {
+	var decision *externalDecision
+	if len(bhAdmission.ExternalAPIURL) > 0 {
 		externalValues := &externalValues{
 			// Kind:        requestKind,
 			// Namespace:   namespace,
 			// AccountName: accountName,
 			EnvName:     "build",
-			ClusterName: clusterName,
+			ClusterName: bhAdmission.ClusterName,
+			Type:        identifierType,
+			Identifier:  identifier,
+			Labels:      labels,
 		}
 		jsonStr, err := json.Marshal(externalValues)
 		if err != nil {
-			logrus.Errorln("Can't marshal externalValues", err)
-		} else {
-			startExternalAPITime := time.Now()
-			err = invokeexternal(externalAPIURL, externalAPITimeout, string(jsonStr))
-			if err != nil {
-				// logrus.Errorln("Invoke external failed:", err)
-				externalAPIError.Inc()
+			log.Errorln("Can't marshal externalValues", err)
+			return nil, err
+		}
+		startExternalAPITime := time.Now()
+		body, err := invokeexternal(log, bhAdmission.ExternalAPIURL, bhAdmission.ExternalAPITimeout, string(jsonStr))
+		if bhAdmission.ExternalAPIMode == ExternalAPIModeGate {
+			if err == nil {
+				decision, err = parseDecision(body)
+			} else if statusDecision, ok := parseStatusDecision(err); ok {
+				decision, err = statusDecision, nil
 			}
-			elapsedExternalAPI := time.Since(startExternalAPITime)
-			// logrus.Debugln("externalAPI elapsed time=", elapsedExternalAPI.Seconds())
-			externalAPIDuration.Observe(float64(elapsedExternalAPI.Seconds()))
 		}
+		if err != nil {
+			// logrus.Errorln("Invoke external failed:", err)
+			externalAPIError.Inc()
+		}
+		elapsedExternalAPI := time.Since(startExternalAPITime)
+		// logrus.Debugln("externalAPI elapsed time=", elapsedExternalAPI.Seconds())
+		externalAPIDuration.Observe(float64(elapsedExternalAPI.Seconds()))
+		return decision, err
+	}
+	return decision, err
+}
+
+// ownerAnnotations returns the annotations added to every new project, namespace and account
+func ownerAnnotations(requester string) map[string]string {
+	return map[string]string{
+		"bnhp.com/requester": requester,
+		ownerAnnotationKey:   requester,
+		"bnhp.cloudia/env":   "build",
+	}
+}
+
+// labelsFor renders the configured labels for the requested object
+func (bhAdmission *BhAdmission) labelsFor(requester string, name string, namespace string) map[string]string {
+	return renderLabels(bhAdmission.Labels, bhAdmission.placeholderValues(requester, name, namespace), bhAdmission.LabelValueMode)
+}
+
+// placeholderValues returns the values of the placeholders supported in label and annotation templates
+func (bhAdmission *BhAdmission) placeholderValues(requester string, name string, namespace string) map[string]string {
+	return map[string]string{
+		"requester": requester,
+		"cluster":   bhAdmission.ClusterName,
+		"name":      name,
+		"namespace": namespace,
+	}
+}
+
+// gateResponse returns a denial when the external API runs in gate mode and either
+// rejects the request or fails while the failure policy is Fail. A nil response
+// means the request may continue.
+func (bhAdmission *BhAdmission) gateResponse(log *logrus.Entry, decision *externalDecision, err error) *v1beta1.AdmissionResponse {
+	if bhAdmission.ExternalAPIMode != ExternalAPIModeGate {
+		return nil
+	}
+	reason := ""
+	if err != nil {
+		if bhAdmission.ExternalAPIFailurePolicy != FailurePolicyFail {
+			log.Warnln("External API failed, request allowed by failure policy:", err)
+			return nil
+		}
+		reason = "external API unavailable: " + err.Error()
+	} else if decision != nil && !decision.Allowed {
+		reason = decision.Reason
+		if len(reason) == 0 {
+			reason = "denied by external API"
+		}
+	} else {
+		return nil
 	}
-	return err
+	externalAPIDenied.Inc()
+	log.WithFields(logrus.Fields{
+		"reason": reason,
+	}).Info("Request denied by external API gate")
+	return denied(reason)
 }
@@ -6,27 +6,18 @@ import (
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
-	restclient "k8s.io/client-go/rest"
+	"namespace-admission-controller/logging"
 	"strings"
 )
 
-func admitNamespace(review *v1beta1.AdmissionReview, externalAPIURL string, externalAPITimeout int32, restConfig restclient.Config, clusterName string) error {
-	var err error
-	request := review.Request
+// namespaceSubject resolves a CREATE request for a project or namespace.
+// Existing projects and namespaces are ignored.
+func (bhAdmission *BhAdmission) namespaceSubject(request *v1beta1.AdmissionRequest) (*admissionSubject, error) {
+	log := logging.ForRequest(request)
 	var ns corev1.Namespace
-	if err = json.Unmarshal(request.Object.Raw, &ns); err != nil {
-		logrus.Errorln("Failed to unmarshal:", err)
-		review.Response = &v1beta1.AdmissionResponse{
-			Allowed: true,
-			Result: &metav1.Status{
-				Status:  metav1.StatusFailure,
-				Message: "Failed to unmarshal: " + err.Error(),
-			},
-		}
-		requestsError.Inc()
-		namespaceRequestsError.Inc()
-		return nil
+	if err := json.Unmarshal(request.Object.Raw, &ns); err != nil {
+		log.Errorln("Failed to unmarshal:", err)
+		return nil, err
 	}
 	// logrus.Debugln("Unmarshalled ns:", ns)
 
@@ -34,50 +25,32 @@ func admitNamespace(review *v1beta1.AdmissionReview, externalAPIURL string, exte
 	if len(namespaceName) == 0 {
 		// backwards compatibility for OCP 3
 		namespaceName = ns.GetName()
-		logrus.Debugln("Namespace set to:", namespaceName)
+		log.Debugln("Namespace set to:", namespaceName)
 	}
 
 	// ignore existing objects
 
 	// A creationTimestamp in the request signifies an existing object
-	//logrus.Debugln("ns.ObjectMeta.GetCreationTimestamp=", ns.ObjectMeta.GetCreationTimestamp())
+	//log.Debugln("ns.ObjectMeta.GetCreationTimestamp=", ns.ObjectMeta.GetCreationTimestamp())
 	t := ns.ObjectMeta.GetCreationTimestamp()
 	if !t.IsZero() {
-		logrus.Info("Inoring create request for project/namespace with creationTime:", namespaceName)
-		return nil
+		log.Info("Inoring create request for project/namespace with creationTime:", namespaceName)
+		return nil, nil
 	}
 
 	// Check whether the object exists
-	coreclient, err := corev1client.NewForConfig(&restConfig)
-	if err != nil {
-		panic(err)
-	}
-	_, err = coreclient.Namespaces().Get(namespaceName, metav1.GetOptions{})
+	_, err := bhAdmission.Clients.Core.Namespaces().Get(namespaceName, metav1.GetOptions{})
 	if err == nil {
-		logrus.Info("Inoring create request for existing project/namespace:", namespaceName)
-		return nil
+		log.Info("Inoring create request for existing project/namespace:", namespaceName)
+		return nil, nil
 	}
 
 	requester := request.UserInfo.Username
-	requestedAnnotations := ns.Annotations
-	// // logrus.Println("ns.Annotations=", ns.Annotations)
-	// if len(ns.Annotations) == 0 {
-	// 	// annotations are not included with "Namespace" creation
-	// 	coreclient, err := corev1client.NewForConfig(&restConfig)
-	// 	if err != nil {
-	// 		panic(err)
-	// 	}
-	// 	nsQuery, err := coreclient.Namespaces().Get(namespaceName, metav1.GetOptions{})
-	// 	if err == nil {
-	// 		requestedAnnotations = nsQuery.Annotations
-	// 		// logrus.Debugln("Found existing annotations", requestedAnnotations)
-	// 	}
-	// }
-	for key, value := range requestedAnnotations {
+	for key, value := range ns.Annotations {
 		// compatibility for OCP "oc new-project <project>"
 		if strings.EqualFold("openshift.io/requester", key) {
 			requester = value
-			logrus.WithFields(logrus.Fields{
+			log.WithFields(logrus.Fields{
 				"from request.UserInfo.Username":     request.UserInfo.Username,
 				"to provided openshift.io/requester": requester,
 			}).Debugln("requester changed")
@@ -85,38 +58,16 @@ func admitNamespace(review *v1beta1.AdmissionReview, externalAPIURL string, exte
 		}
 	}
 
-	requestsHandled.Inc()
-	namespaceRequestsHandled.Inc()
-
-	newAnnotations := map[string]string{
-		"bnhp.com/requester": requester,
-		"bnhp.cloudia/owner": requester,
-		"bnhp.cloudia/env":   "build",
-	}
-
-	patchBytes, err := createPatch(ns.Annotations, newAnnotations)
-	if err != nil {
-		review.Response = &v1beta1.AdmissionResponse{
-			Allowed: true,
-			Result: &metav1.Status{
-				Status:  metav1.StatusFailure,
-				Message: "createPatch failed: " + err.Error(),
-			},
-		}
-		requestsError.Inc()
-		namespaceRequestsError.Inc()
-		return nil
-	}
-
-	logrus.Debugln("AdmissionResponse:", string(patchBytes))
-	review.Response = &v1beta1.AdmissionResponse{
-		Allowed: true,
-		Patch:   patchBytes,
-		PatchType: func() *v1beta1.PatchType {
-			pt := v1beta1.PatchTypeJSONPatch
-			return &pt
-		}(),
-	}
-
-	return nil
+	return &admissionSubject{
+		name:           namespaceName,
+		requester:      requester,
+		identifierType: "namespace",
+		identifier:     namespaceName,
+		// projects require pre-approval only when the external API acts as a gate
+		register:       bhAdmission.ExternalAPIMode == ExternalAPIModeGate,
+		annotations:    ns.Annotations,
+		labels:         ns.Labels,
+		newAnnotations: ownerAnnotations(requester),
+		newLabels:      bhAdmission.labelsFor(requester, namespaceName, namespaceName),
+	}, nil
 }
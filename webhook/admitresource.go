@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"namespace-admission-controller/logging"
+)
+
+// resourceSubject resolves a request for any kind of resource matching a resource rule
+func (bhAdmission *BhAdmission) resourceSubject(request *v1beta1.AdmissionRequest, rule *ResourceRule) (*admissionSubject, error) {
+	log := logging.ForRequest(request)
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(request.Object.Raw); err != nil {
+		log.Errorln("Failed to unmarshal "+request.Kind.Kind+":", err)
+		return nil, err
+	}
+
+	requestName := request.Name
+	if len(requestName) == 0 {
+		requestName = obj.GetName()
+	}
+	requester := request.UserInfo.Username
+	values := bhAdmission.placeholderValues(requester, requestName, request.Namespace)
+
+	newAnnotations := renderTemplates(rule.Annotations, values)
+	if len(newAnnotations) == 0 {
+		newAnnotations = ownerAnnotations(requester)
+	}
+
+	newLabels := renderLabels(rule.Labels, values, bhAdmission.LabelValueMode)
+	if request.Operation == v1beta1.Update {
+		// the owner is set when the object is created, an update by another user only adds missing keys
+		newAnnotations = missingKeys(obj.GetAnnotations(), newAnnotations)
+		newLabels = missingKeys(obj.GetLabels(), newLabels)
+	}
+
+	identifierType := rule.IdentifierType
+	if len(identifierType) == 0 {
+		identifierType = rule.Resource
+	}
+	identifier := requestName
+	if len(request.Namespace) > 0 {
+		identifier = request.Namespace + "-" + requestName
+	}
+
+	return &admissionSubject{
+		name:           requestName,
+		requester:      requester,
+		identifierType: identifierType,
+		identifier:     identifier,
+		// objects created with generateName have no name to register yet, updated objects are already registered
+		register:       rule.RegisterExternal && request.Operation == v1beta1.Create && len(requestName) > 0,
+		annotations:    obj.GetAnnotations(),
+		labels:         obj.GetLabels(),
+		newAnnotations: newAnnotations,
+		newLabels:      newLabels,
+	}, nil
+}
+
+// missingKeys returns the entries of added whose keys are not in existing
+func missingKeys(existing map[string]string, added map[string]string) map[string]string {
+	missing := map[string]string{}
+	for key, value := range added {
+		if _, ok := existing[key]; !ok {
+			missing[key] = value
+		}
+	}
+	return missing
+}
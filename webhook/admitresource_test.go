@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"encoding/json"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestAdmitResource(t *testing.T) {
+	rules, err := ParseResourceRules([]byte(`
+rules:
+- group: ""
+  version: v1
+  resource: secrets
+  annotations:
+    company.com/created-by: "{requester}"
+  labels:
+    owner: "{requester}"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bhAdmission := &BhAdmission{
+		ResourceRules:  rules,
+		LabelValueMode: LabelValueModeSanitize,
+	}
+	review := &v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "e911857d-c318-11e8-bbad-025000000001",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Secret"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "secrets"},
+			Namespace: "test",
+			Operation: v1beta1.Create,
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "test", "labels": {"app": "test"}}}`),
+			},
+		},
+	}
+	review.Request.UserInfo.Username = "kube:admin"
+	if err := bhAdmission.HandleAdmission(review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil || !review.Response.Allowed {
+		t.Fatal("Request was not allowed:", review.Response)
+	}
+	var patch []patchOperation
+	if err := json.Unmarshal(review.Response.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	expected := []patchOperation{
+		{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{"company.com/created-by": "kube:admin"}},
+		{Op: "add", Path: "/metadata/labels/owner", Value: "kube_admin"},
+	}
+	if len(patch) != len(expected) {
+		t.Fatal("Unexpected patch:", patch)
+	}
+	for i := range expected {
+		if patch[i].Path != expected[i].Path || patch[i].Op != expected[i].Op {
+			t.Error("Unexpected patch operation:", patch[i])
+		}
+	}
+
+	review.Request.Resource.Resource = "configmaps"
+	review.Response = nil
+	_ = bhAdmission.HandleAdmission(review)
+	if review.Response != nil {
+		t.Error("Resource without rule was mutated:", review.Response)
+	}
+}
+
+func TestResourceRegistration(t *testing.T) {
+	rules, err := ParseResourceRules([]byte(`
+rules:
+- group: route.openshift.io
+  version: v1
+  resource: routes
+  operations: ["CREATE", "UPDATE"]
+  registerExternal: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bhAdmission := &BhAdmission{ResourceRules: rules}
+	request := &v1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "route.openshift.io", Version: "v1", Kind: "Route"},
+		Resource:  metav1.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "routes"},
+		Name:      "web",
+		Namespace: "test",
+		Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion": "route.openshift.io/v1", "kind": "Route", "metadata": {"name": "web"}}`)},
+	}
+	tests := []struct {
+		operation   v1beta1.Operation
+		subResource string
+		handled     bool
+		register    bool
+	}{
+		{v1beta1.Create, "", true, true},
+		{v1beta1.Update, "", true, false},
+		{v1beta1.Update, "status", false, false},
+	}
+	for _, test := range tests {
+		request.Operation = test.operation
+		request.SubResource = test.subResource
+		name := string(test.operation) + " " + test.subResource
+		rule := bhAdmission.resourceRule(request)
+		if (rule != nil) != test.handled {
+			t.Errorf("%s: expected handled %v", name, test.handled)
+			continue
+		}
+		if rule == nil {
+			continue
+		}
+		subject, err := bhAdmission.resourceSubject(request, rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject.register != test.register {
+			t.Errorf("%s: expected register %v, got %v", name, test.register, subject.register)
+		}
+	}
+}
+
+func TestResourceUpdateKeepsOwner(t *testing.T) {
+	rules, err := ParseResourceRules([]byte(`
+rules:
+- group: ""
+  version: v1
+  resource: secrets
+  operations: ["CREATE", "UPDATE"]
+  annotations:
+    company.com/created-by: "{requester}"
+  labels:
+    owner: "{requester}"
+    team: "core"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bhAdmission := &BhAdmission{ResourceRules: rules, LabelValueMode: LabelValueModeSanitize}
+	request := &v1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Secret"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "secrets"},
+		Name:      "test",
+		Namespace: "test",
+		Operation: v1beta1.Update,
+		Object: runtime.RawExtension{
+			Raw: []byte(`{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "test",
+				"annotations": {"company.com/created-by": "alice"}, "labels": {"owner": "alice"}}}`),
+		},
+	}
+	request.UserInfo.Username = "bob"
+	subject, err := bhAdmission.resourceSubject(request, bhAdmission.resourceRule(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subject.newAnnotations) != 0 {
+		t.Error("Update replaced the owner annotations:", subject.newAnnotations)
+	}
+	if len(subject.newLabels) != 1 || subject.newLabels["team"] != "core" {
+		t.Error("Expected only the missing label, got:", subject.newLabels)
+	}
+}
@@ -1,39 +1,45 @@
 package webhook
 
 import (
-	"encoding/json"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/sirupsen/logrus"
 	"k8s.io/api/admission/v1beta1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"runtime/debug"
-	"strings"
-	"time"
-
-	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"namespace-admission-controller/server"
+	"regexp"
 )
 
 // BhAdmission request
 type BhAdmission struct {
-	ExternalAPIURL     string
-	ExternalAPITimeout int32
-	RequesterKey       string
-	RestConfig         restclient.Config
-	ClusterName        string
+	ExternalAPIURL           string
+	ExternalAPITimeout       int32
+	ExternalAPIMode          string
+	ExternalAPIFailurePolicy string
+	RequesterKey             string
+	Labels                   map[string]string
+	LabelValueMode           string
+	ResourceRules            []ResourceRule
+	NamingPolicy             *regexp.Regexp
+	MaxNamespacesPerOwner    int
+	// QuotaFailurePolicy decides the requests when the owned namespaces can not be listed
+	QuotaFailurePolicy string
+	// Clients look up existing objects, they are required for projects, namespaces and accounts
+	Clients     Clients
+	ClusterName string
+	// Recorder records the registrations, failures and denials as events, none when nil
+	Recorder record.EventRecorder
+
+	subjects subjectCache
 }
 
 const (
 	prefix          = "bhadmission"
 	prefixNamespace = "namespace"
 	prefixAccount   = "account"
+	prefixResource  = "resource"
 )
 
 var (
-	requestsTotal = promauto.NewCounter(prometheus.CounterOpts{
-		Name: prefix + "_requests_total",
-		Help: "The total number of processed requests",
-	})
 	namespaceRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
 		Name: prefix + "_" + prefixNamespace + "_requests_total",
 		Help: "The total number of processed namespace requests",
@@ -42,9 +48,9 @@ var (
 		Name: prefix + "_" + prefixAccount + "_requests_total",
 		Help: "The total number of processed account requests",
 	})
-	requestsHandled = promauto.NewCounter(prometheus.CounterOpts{
-		Name: prefix + "_requests_handled",
-		Help: "The total number of processed requests",
+	resourceRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: prefix + "_" + prefixResource + "_requests_total",
+		Help: "The total number of processed requests for resources matching a resource rule",
 	})
 	namespaceRequestsHandled = promauto.NewCounter(prometheus.CounterOpts{
 		Name: prefix + "_" + prefixNamespace + "_requests_handled",
@@ -54,9 +60,9 @@ var (
 		Name: prefix + "_" + prefixAccount + "_requests_handled",
 		Help: "The total number of processed account requests",
 	})
-	requestsError = promauto.NewCounter(prometheus.CounterOpts{
-		Name: prefix + "_requests_error",
-		Help: "The total number of requests in error",
+	resourceRequestsHandled = promauto.NewCounter(prometheus.CounterOpts{
+		Name: prefix + "_" + prefixResource + "_requests_handled",
+		Help: "The total number of processed requests for resources matching a resource rule",
 	})
 	namespaceRequestsError = promauto.NewCounter(prometheus.CounterOpts{
 		Name: prefix + "_" + prefixNamespace + "_requests_error",
@@ -66,10 +72,9 @@ var (
 		Name: prefix + "_" + prefixAccount + "_requests_error",
 		Help: "The total number of accounts requests in error",
 	})
-	requestsDuration = promauto.NewHistogram(prometheus.HistogramOpts{
-		Name:    prefix + "_requests_duration",
-		Help:    "The durations of all requests",
-		Buckets: prometheus.LinearBuckets(1, 3, 5),
+	resourceRequestsError = promauto.NewCounter(prometheus.CounterOpts{
+		Name: prefix + "_" + prefixResource + "_requests_error",
+		Help: "The total number of requests in error for resources matching a resource rule",
 	})
 	namespaceRequestsDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    prefix + "_" + prefixNamespace + "_requests_duration",
@@ -81,92 +86,44 @@ var (
 		Help:    "The durations of account requests",
 		Buckets: prometheus.LinearBuckets(1, 3, 5),
 	})
+	resourceRequestsDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    prefix + "_" + prefixResource + "_requests_duration",
+		Help:    "The durations of requests for resources matching a resource rule",
+		Buckets: prometheus.LinearBuckets(1, 3, 5),
+	})
 	externalAPIError = promauto.NewCounter(prometheus.CounterOpts{
 		Name: prefix + "_external_api_error",
 		Help: "The total number of external API invocations in error",
 	})
+	externalAPIDenied = promauto.NewCounter(prometheus.CounterOpts{
+		Name: prefix + "_external_api_denied",
+		Help: "The total number of requests denied by the external API gate",
+	})
 	externalAPIDuration = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    prefix + "_external_api_duration",
 		Help:    "The durations of external API invocations",
 		Buckets: prometheus.LinearBuckets(1, 3, 5),
 	})
+	quotaError = promauto.NewCounter(prometheus.CounterOpts{
+		Name: prefix + "_quota_error",
+		Help: "The total number of quota checks that failed to list the namespaces of the owner",
+	})
 )
 
-// HandleAdmission invoked when a new namespace or project is created
-func (bhAdmission *BhAdmission) HandleAdmission(review *v1beta1.AdmissionReview) error {
-	defer func() {
-		if r := recover(); r != nil {
-			logrus.Error("Recovering from panic:\n", string(debug.Stack()))
-			review.Response = &v1beta1.AdmissionResponse{
-				Allowed: true,
-				Result: &metav1.Status{
-					Status:  metav1.StatusFailure,
-					Message: "Internal error",
-				},
-			}
-			return
-		}
-	}()
-
-	if review.Request == nil {
-		logrus.Info("EMPTY REQUEST for HandleAdmission - ignored")
-		review.Response = &v1beta1.AdmissionResponse{
-			Allowed: true,
-			Result: &metav1.Status{
-				Status:  metav1.StatusFailure,
-				Message: "Invalid AdmissionReview",
-			},
-		}
-		return nil
-	}
-
-	logrus.WithFields(logrus.Fields{
-		"Operation": review.Request.Operation,
-		"Kind":      review.Request.Kind.Kind,
-		"Name":      review.Request.Name,
-		"User":      review.Request.UserInfo.Username,
-	}).Info("NEW REQUEST for HandleAdmission")
+var categoryMetrics = map[string]*server.RequestMetrics{
+	prefixNamespace: {Total: namespaceRequestsTotal, Handled: namespaceRequestsHandled, Errors: namespaceRequestsError, Duration: namespaceRequestsDuration},
+	prefixAccount:   {Total: accountRequestsTotal, Handled: accountRequestsHandled, Errors: accountRequestsError, Duration: accountRequestsDuration},
+	prefixResource:  {Total: resourceRequestsTotal, Handled: resourceRequestsHandled, Errors: resourceRequestsError, Duration: resourceRequestsDuration},
+}
 
-	// Code that prints the review as JSON in []byte format
-	b, err := json.Marshal(review)
-	if err != nil {
-		logrus.Debugln("failed to unmarshal review")
-	}
-	var rev v1beta1.AdmissionReview
-	if err := json.Unmarshal(b, &rev); err != nil {
-		logrus.Errorln("Failed to unmarshal review:", err)
-	}
-	logrus.WithFields(logrus.Fields{
-		"json": b,
-	}).Info("reviewAsJSON")
+// RequestMetrics returns the metrics of the category of the request, a server.MetricsFunc
+func (bhAdmission *BhAdmission) RequestMetrics(request *v1beta1.AdmissionRequest) *server.RequestMetrics {
+	return categoryMetrics[bhAdmission.category(request)]
+}
 
-	request := review.Request
-	reqKind := request.Kind
-	if request.Operation == v1beta1.Create {
-		requestKind := reqKind.Kind
-		if strings.EqualFold("Namespace", requestKind) ||
-			strings.EqualFold("Project", requestKind) {
-			requestsTotal.Inc()
-			namespaceRequestsTotal.Inc()
-			startRequestTime := time.Now()
-			_ = admitNamespace(review, bhAdmission.ExternalAPIURL, bhAdmission.ExternalAPITimeout, bhAdmission.RestConfig, bhAdmission.ClusterName)
-			elapsed := time.Since(startRequestTime)
-			// logrus.Debugln("request elapsed time=", elapsed.Seconds())
-			requestsDuration.Observe(float64(elapsed.Seconds()))
-			namespaceRequestsDuration.Observe(float64(elapsed.Seconds()))
-		} else if strings.EqualFold("User", requestKind) ||
-			strings.EqualFold("ServiceAccount", requestKind) {
-			requestsTotal.Inc()
-			startRequestTime := time.Now()
-			accountRequestsTotal.Inc()
-			_ = admitAccount(review, bhAdmission.ExternalAPIURL, bhAdmission.ExternalAPITimeout, bhAdmission.RestConfig, bhAdmission.ClusterName)
-			elapsed := time.Since(startRequestTime)
-			// logrus.Debugln("request elapsed time=", elapsed.Seconds())
-			requestsDuration.Observe(float64(elapsed.Seconds()))
-			accountRequestsDuration.Observe(float64(elapsed.Seconds()))
-		} else {
-			logrus.Debug("Ignoring AdmissingRequest for type:", reqKind.Kind)
-		}
-	}
-	return nil
+// HandleAdmission runs all plugins in their default order
+func (bhAdmission *BhAdmission) HandleAdmission(review *v1beta1.AdmissionReview) error {
+	chain := server.NewPluginChain(bhAdmission.Plugins()...)
+	chain.Metrics = bhAdmission.RequestMetrics
+	return chain.HandleAdmission(review)
 }
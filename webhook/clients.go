@@ -0,0 +1,27 @@
+package webhook
+
+import (
+	userv1client "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+)
+
+// Clients are the API clients of the plugins. The fake clientsets of client-go and of the
+// OpenShift client implement them, see the webhooktest package.
+type Clients struct {
+	Core corev1client.CoreV1Interface
+	User userv1client.UserV1Interface
+}
+
+// NewClients creates the clients of the API server
+func NewClients(restConfig *restclient.Config) (Clients, error) {
+	core, err := corev1client.NewForConfig(restConfig)
+	if err != nil {
+		return Clients{}, err
+	}
+	user, err := userv1client.NewForConfig(restConfig)
+	if err != nil {
+		return Clients{}, err
+	}
+	return Clients{Core: core, User: user}, nil
+}
@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
+	"namespace-admission-controller/logging"
+)
+
+// EventComponent is the source of the events recorded by the webhook
+const EventComponent = "bh-admission"
+
+// Reasons of the events
+const (
+	// EventRegistered is recorded when the external API accepted the registration of the object
+	EventRegistered = "Registered"
+	// EventRegistrationFailed is recorded when the external API failed and the request was allowed
+	EventRegistrationFailed = "RegistrationFailed"
+	// EventPatchFailed is recorded when the owner annotations and labels could not be added
+	EventPatchFailed = "PatchFailed"
+	// EventDenied is recorded when a policy or the external API gate denied the request
+	EventDenied = "Denied"
+)
+
+// EventOptions configures the events recorded by the plugins
+type EventOptions struct {
+	Enabled bool
+	// QPS and Burst limit the events of all requests together, the events beyond are dropped
+	QPS   float32
+	Burst int
+}
+
+var eventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: prefix + "_events_dropped_total",
+	Help: "The total number of events dropped by the rate limit",
+})
+
+// NewEventBroadcaster sends the recorded events to the API server. The broadcaster also
+// aggregates repeated events of the same object.
+func NewEventBroadcaster(core corev1client.CoreV1Interface) record.EventBroadcaster {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: core.Events("")})
+	return broadcaster
+}
+
+// NewEventRecorder returns a recorder of the broadcaster limited to options.QPS events per second,
+// nil when the events are disabled
+func NewEventRecorder(broadcaster record.EventBroadcaster, options EventOptions) record.EventRecorder {
+	if !options.Enabled || broadcaster == nil {
+		return nil
+	}
+	return &rateLimitedRecorder{
+		EventRecorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: EventComponent}),
+		limiter:       flowcontrol.NewTokenBucketRateLimiter(options.QPS, options.Burst),
+	}
+}
+
+// rateLimitedRecorder drops the events beyond the rate of its limiter, so that a failing external
+// API does not flood the API server with one event per request
+type rateLimitedRecorder struct {
+	record.EventRecorder
+	limiter flowcontrol.RateLimiter
+}
+
+func (recorder *rateLimitedRecorder) accept() bool {
+	if recorder.limiter.TryAccept() {
+		return true
+	}
+	eventsDropped.Inc()
+	return false
+}
+
+func (recorder *rateLimitedRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if recorder.accept() {
+		recorder.EventRecorder.Event(object, eventtype, reason, message)
+	}
+}
+
+func (recorder *rateLimitedRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if recorder.accept() {
+		recorder.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (recorder *rateLimitedRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	if recorder.accept() {
+		recorder.EventRecorder.PastEventf(object, timestamp, eventtype, reason, messageFmt, args...)
+	}
+}
+
+func (recorder *rateLimitedRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	if recorder.accept() {
+		recorder.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// eventReference returns the object the events of a request are attached to. Accounts and
+// resources record their events in their parent namespace. Projects and namespaces do not
+// exist yet at admission time and the API server rejects events in a missing namespace, so
+// their events go to the default namespace like those of users and other cluster-scoped objects.
+func (bhAdmission *BhAdmission) eventReference(request *v1beta1.AdmissionRequest, name string) *corev1.ObjectReference {
+	if bhAdmission.category(request) == prefixNamespace {
+		return &corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: name}
+	}
+	return &corev1.ObjectReference{
+		APIVersion: schema.GroupVersion{Group: request.Kind.Group, Version: request.Kind.Version}.String(),
+		Kind:       request.Kind.Kind,
+		Name:       name,
+		Namespace:  request.Namespace,
+	}
+}
+
+// recordEvent records an event about the object of the request when a recorder is configured
+func (bhAdmission *BhAdmission) recordEvent(request *v1beta1.AdmissionRequest, name string, eventType string, reason string, message string) {
+	// the webhooks have no side effects on dry-run
+	if bhAdmission.Recorder == nil || (request.DryRun != nil && *request.DryRun) {
+		return
+	}
+	ref := bhAdmission.eventReference(request, name)
+	logging.ForRequest(request).WithFields(logrus.Fields{
+		"reason": reason,
+	}).Debugln("Recording event for", ref.Kind, ref.Namespace+"/"+ref.Name)
+	bhAdmission.Recorder.Event(ref, eventType, reason, message)
+}
@@ -0,0 +1,182 @@
+package webhook_test
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"namespace-admission-controller/webhook"
+	"namespace-admission-controller/webhook/webhooktest"
+)
+
+// matrixCase returns the case of the matrix with the name
+func matrixCase(t *testing.T, name string) webhooktest.Case {
+	for _, c := range webhooktest.Matrix() {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatal("no case " + name + " in the matrix")
+	return webhooktest.Case{}
+}
+
+func TestEvents(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	externalAPI.DiscardCalls()
+	tests := []struct {
+		name         string
+		mode         string
+		namingPolicy string
+		status       int
+		response     string
+		eventType    string
+		reason       string
+		message      string
+		// reference is the kind, namespace and name of the object of the event
+		reference corev1.ObjectReference
+	}{
+		{
+			name: "serviceaccount/oc create", mode: webhook.ExternalAPIModeNotify, status: http.StatusOK, response: `{"allowed": true}`,
+			eventType: corev1.EventTypeNormal, reason: webhook.EventRegistered, message: "Registered sa team-a-test with the external API",
+			reference: corev1.ObjectReference{Kind: "ServiceAccount", Namespace: "team-a", Name: "test"},
+		},
+		{
+			name: "user/oc create", mode: webhook.ExternalAPIModeNotify, status: http.StatusInternalServerError, response: `{}`,
+			eventType: corev1.EventTypeWarning, reason: webhook.EventRegistrationFailed, message: "Registration of user test with the external API failed: external API returned HTTP 500",
+			reference: corev1.ObjectReference{Kind: "User", Name: "test"},
+		},
+		{
+			name: "project/oc create", mode: webhook.ExternalAPIModeGate, status: http.StatusOK, response: `{"allowed": true}`,
+			eventType: corev1.EventTypeNormal, reason: webhook.EventRegistered, message: "Registered namespace test with the external API",
+			reference: corev1.ObjectReference{Kind: "Namespace", Name: "test"},
+		},
+		{
+			name: "namespace/oc create", mode: webhook.ExternalAPIModeGate, status: http.StatusOK, response: `{"allowed": false, "reason": "not approved"}`,
+			eventType: corev1.EventTypeWarning, reason: webhook.EventDenied, message: "not approved",
+			reference: corev1.ObjectReference{Kind: "Namespace", Name: "test"},
+		},
+		{
+			name: "project/oc create", mode: webhook.ExternalAPIModeNotify, namingPolicy: "^team-", status: http.StatusOK, response: `{"allowed": true}`,
+			eventType: corev1.EventTypeWarning, reason: webhook.EventDenied, message: "name test does not match the naming policy ^team-",
+			reference: corev1.ObjectReference{Kind: "Namespace", Name: "test"},
+		},
+	}
+	for _, test := range tests {
+		c := matrixCase(t, "regular user/"+test.name)
+		recorder := &webhooktest.Recorder{}
+		externalAPI.Respond(test.status, test.response)
+		bhAdmission := &webhook.BhAdmission{
+			ExternalAPIURL:     externalAPI.URL(),
+			ExternalAPITimeout: 5,
+			ExternalAPIMode:    test.mode,
+			LabelValueMode:     webhook.LabelValueModeSanitize,
+			Clients:            c.Cluster().Clients(),
+			Recorder:           recorder,
+		}
+		if len(test.namingPolicy) > 0 {
+			bhAdmission.NamingPolicy = regexp.MustCompile(test.namingPolicy)
+		}
+		review := &v1beta1.AdmissionReview{Request: c.Review.Request.DeepCopy()}
+		if err := bhAdmission.HandleAdmission(review); err != nil {
+			t.Fatal(test.name+":", err)
+		}
+		events := recorder.Take()
+		if len(events) != 1 {
+			t.Errorf("%s: expected a single event, got %+v", test.name, events)
+			continue
+		}
+		event := events[0]
+		ref, ok := event.Object.(*corev1.ObjectReference)
+		if !ok || ref.Kind != test.reference.Kind || ref.Namespace != test.reference.Namespace || ref.Name != test.reference.Name {
+			t.Errorf("%s: expected an event of %+v, got %+v", test.name, test.reference, event.Object)
+		}
+		if event.Type != test.eventType || event.Reason != test.reason || event.Message != test.message {
+			t.Errorf("%s: unexpected event %s %s %q", test.name, event.Type, event.Reason, event.Message)
+		}
+	}
+}
+
+// TestEventNamespace posts the events through the broadcaster, the events of a new project are
+// recorded in the default namespace because the project does not exist yet
+func TestEventNamespace(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	tests := []struct {
+		name      string
+		namespace string
+		kind      string
+	}{
+		{"project/oc create", "default", "Namespace"},
+		{"serviceaccount/oc create", "team-a", "ServiceAccount"},
+	}
+	for _, test := range tests {
+		c := matrixCase(t, "regular user/"+test.name)
+		cluster := c.Cluster()
+		// the fake clientset does not store events posted without a namespace, the reactor keeps them
+		posted := make(chan *corev1.Event, 10)
+		cluster.Kube.PrependReactor("create", "events", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			event := action.(clienttesting.CreateAction).GetObject().(*corev1.Event)
+			posted <- event
+			return true, event, nil
+		})
+		broadcaster := webhook.NewEventBroadcaster(cluster.Kube.CoreV1())
+		bhAdmission := &webhook.BhAdmission{
+			ExternalAPIURL:     externalAPI.URL(),
+			ExternalAPITimeout: 5,
+			ExternalAPIMode:    webhook.ExternalAPIModeGate,
+			LabelValueMode:     webhook.LabelValueModeSanitize,
+			Clients:            cluster.Clients(),
+			Recorder:           webhook.NewEventRecorder(broadcaster, webhook.EventOptions{Enabled: true, QPS: 1, Burst: 1}),
+		}
+		review := &v1beta1.AdmissionReview{Request: c.Review.Request.DeepCopy()}
+		if err := bhAdmission.HandleAdmission(review); err != nil {
+			t.Fatal(test.name+":", err)
+		}
+		select {
+		case event := <-posted:
+			if event.Namespace != test.namespace || event.InvolvedObject.Kind != test.kind || event.InvolvedObject.Name != "test" ||
+				event.Reason != webhook.EventRegistered {
+				t.Errorf("%s: expected a Registered event of %s test in %s, got %+v", test.name, test.kind, test.namespace, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: no event was posted", test.name)
+		}
+		broadcaster.Shutdown()
+	}
+}
+
+func TestEventRecorderRateLimit(t *testing.T) {
+	broadcaster := record.NewBroadcaster()
+	defer broadcaster.Shutdown()
+	received := make(chan *corev1.Event, 10)
+	broadcaster.StartEventWatcher(func(event *corev1.Event) { received <- event })
+	if webhook.NewEventRecorder(broadcaster, webhook.EventOptions{Enabled: false, QPS: 1, Burst: 1}) != nil {
+		t.Error("expected no recorder when the events are disabled")
+	}
+	recorder := webhook.NewEventRecorder(broadcaster, webhook.EventOptions{Enabled: true, QPS: 0.01, Burst: 3})
+	for i := 0; i < 5; i++ {
+		recorder.Event(&corev1.ObjectReference{Kind: "Namespace", Name: "test", Namespace: "test"}, corev1.EventTypeNormal, webhook.EventRegistered, "registered")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-received:
+			if event.Source.Component != webhook.EventComponent || event.Namespace != "test" {
+				t.Errorf("unexpected event %+v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 3 events, got %d", i)
+		}
+	}
+	select {
+	case event := <-received:
+		t.Errorf("event beyond the burst was recorded: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -1,10 +1,12 @@
 package webhook
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -12,41 +14,85 @@ import (
 // InfraGateToken value
 var InfraGateToken string
 
-func invokeexternal(apiURL string, apiTimeout int32, jsondata string) error {
+// externalDecision is the body returned by the external API in gate mode
+type externalDecision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// statusError is returned for responses other than HTTP 200. The body may still hold a
+// decision, for example a denial with HTTP 403.
+type statusError struct {
+	statusCode int
+	body       []byte
+}
+
+func (err *statusError) Error() string {
+	return "external API returned HTTP " + strconv.Itoa(err.statusCode)
+}
+
+func invokeexternal(log *logrus.Entry, apiURL string, apiTimeout int32, jsondata string) ([]byte, error) {
 	client := &http.Client{
 		Timeout: time.Duration(apiTimeout) * time.Second,
 	}
 	// Do not use http.Post as timeout cannot be used
 	req, err := http.NewRequest("POST", apiURL, strings.NewReader(jsondata))
 	if err != nil {
-		logrus.Errorln("http.NewRequest failed:", err)
-		return err
+		log.Errorln("http.NewRequest failed:", err)
+		return nil, err
 	}
 	req.Header.Add("Accept", "application/json")
-	logrus.WithFields(logrus.Fields{
+	log.WithFields(logrus.Fields{
 		"URL":     apiURL,
 		"Timeout": apiTimeout,
 		"JSON":    jsondata,
 	}).Debug("Invoking external URL")
 	response, err := client.Do(req)
 	if err != nil {
-		logrus.Errorln("External API failed:", err)
-		return err
+		log.Errorln("External API failed:", err)
+		return nil, err
 	}
 
 	defer response.Body.Close()
 
 	bytes, _ := ioutil.ReadAll(response.Body)
 
-	contextLogger := logrus.WithFields(logrus.Fields{
+	contextLogger := log.WithFields(logrus.Fields{
 		"HTTP Status Code": response.StatusCode,
 		"response":         string(bytes),
 	})
 
 	if response.StatusCode != http.StatusOK {
 		contextLogger.Error("External API invocation FAILED")
-		return errors.New("Failed")
+		return nil, &statusError{statusCode: response.StatusCode, body: bytes}
 	}
 	contextLogger.Infoln("External API invocation succeeded")
-	return nil
+	return bytes, nil
+}
+
+// parseDecision reads the allow or deny decision returned by the external API in gate mode
+func parseDecision(body []byte) (*externalDecision, error) {
+	var decision externalDecision
+	if err := json.Unmarshal(body, &decision); err != nil {
+		return nil, errors.New("invalid decision from external API: " + err.Error())
+	}
+	return &decision, nil
+}
+
+// parseStatusDecision reads the decision of a response other than HTTP 200. Only a body with
+// an allowed field is a decision, other bodies are failures of the external API.
+func parseStatusDecision(err error) (*externalDecision, bool) {
+	status, ok := err.(*statusError)
+	if !ok {
+		return nil, false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(status.body, &fields) != nil {
+		return nil, false
+	}
+	if _, ok := fields["allowed"]; !ok {
+		return nil, false
+	}
+	decision, parseErr := parseDecision(status.body)
+	return decision, parseErr == nil
 }
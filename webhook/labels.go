@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sort"
+	"strings"
+)
+
+const (
+	// LabelValueModeSanitize replaces characters that are not allowed in label values
+	LabelValueModeSanitize = "sanitize"
+	// LabelValueModeHash replaces label values rendered from placeholders with a hash of the original value
+	LabelValueModeHash = "hash"
+
+	// label values are limited to 63 characters
+	maxLabelValueLength = validation.LabelValueMaxLength
+	// length of the hash used for hashed or truncated label values
+	labelHashLength = 10
+)
+
+// ParseLabels parses a comma separated list of key={placeholder} pairs, for example
+// "owner={requester},env=build". Supported placeholders are {requester}, {cluster},
+// {name} and {namespace}.
+func ParseLabels(spec string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("label " + pair + " must be in the form key=value")
+		}
+		key := strings.TrimSpace(kv[0])
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, errors.New("invalid label key " + key + ": " + strings.Join(errs, "; "))
+		}
+		labels[key] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+// OwnerLabel returns the key of the configured label holding the requester, the label counted by the
+// quota plugin. It is empty when no label has the template {requester}.
+func OwnerLabel(templates map[string]string) string {
+	keys := make([]string, 0, len(templates))
+	for key := range templates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if templates[key] == "{requester}" {
+			return key
+		}
+	}
+	return ""
+}
+
+// renderTemplates replaces the placeholders in the templates with their values
+func renderTemplates(templates map[string]string, values map[string]string) map[string]string {
+	if len(templates) == 0 {
+		return nil
+	}
+	placeholders := make([]string, 0, len(values)*2)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		placeholders = append(placeholders, "{"+k+"}", values[k])
+	}
+	replacer := strings.NewReplacer(placeholders...)
+	rendered := map[string]string{}
+	for key, template := range templates {
+		rendered[key] = replacer.Replace(template)
+	}
+	return rendered
+}
+
+// renderLabels replaces the placeholders in the configured label templates and
+// converts the results into valid label values. Constant values, for example env=build,
+// are only sanitized so that they can still be selected in hash mode.
+func renderLabels(templates map[string]string, values map[string]string, mode string) map[string]string {
+	labels := renderTemplates(templates, values)
+	for key, value := range labels {
+		if value == templates[key] {
+			labels[key] = labelValue(value, LabelValueModeSanitize)
+		} else {
+			labels[key] = labelValue(value, mode)
+		}
+	}
+	return labels
+}
+
+// labelValue converts value into a valid label value, for example "kube:admin"
+// becomes "kube_admin" and "john@company.com" becomes "john_company.com".
+// Sanitizing is not unique, "kube:admin" and "kube_admin" have the same label value.
+func labelValue(value string, mode string) string {
+	if len(value) == 0 {
+		return value
+	}
+	if mode == LabelValueModeHash {
+		return hashValue(value)
+	}
+	if len(validation.IsValidLabelValue(value)) == 0 {
+		return value
+	}
+	sanitized := []rune{}
+	for _, r := range value {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			sanitized = append(sanitized, r)
+		} else {
+			sanitized = append(sanitized, '_')
+		}
+	}
+	result := strings.Trim(string(sanitized), "-_.")
+	if len(result) > maxLabelValueLength {
+		// keep the values unique by appending a hash of the original value
+		result = strings.TrimRight(result[:maxLabelValueLength-labelHashLength-1], "-_.") + "-" + hashValue(value)
+	}
+	if len(result) == 0 {
+		return hashValue(value)
+	}
+	return result
+}
+
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:labelHashLength]
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+	"testing"
+)
+
+func TestLabelValue(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		value    string
+		mode     string
+		expected string
+	}{
+		{"michael", LabelValueModeSanitize, "michael"},
+		{"kube:admin", LabelValueModeSanitize, "kube_admin"},
+		{"john.doe@company.com", LabelValueModeSanitize, "john.doe_company.com"},
+		{"system:serviceaccount:ns:sa", LabelValueModeSanitize, "system_serviceaccount_ns_sa"},
+		{":::", LabelValueModeSanitize, hashValue(":::")},
+		{long, LabelValueModeSanitize, long[:52] + "-" + hashValue(long)},
+		{"kube:admin", LabelValueModeHash, hashValue("kube:admin")},
+		{"", LabelValueModeSanitize, ""},
+	}
+	for _, test := range tests {
+		value := labelValue(test.value, test.mode)
+		if value != test.expected {
+			t.Errorf("labelValue(%q, %s) = %q, expected %q", test.value, test.mode, value, test.expected)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			t.Errorf("labelValue(%q, %s) = %q is not a valid label value: %v", test.value, test.mode, value, errs)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("owner={requester}, bnhp.cloudia/env=build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels["owner"] != "{requester}" || labels["bnhp.cloudia/env"] != "build" {
+		t.Error("Unexpected labels:", labels)
+	}
+	rendered := renderLabels(labels, map[string]string{"requester": "kube:admin"}, LabelValueModeSanitize)
+	if rendered["owner"] != "kube_admin" {
+		t.Error("Unexpected rendered labels:", rendered)
+	}
+	// only the values of placeholders are hashed
+	rendered = renderLabels(labels, map[string]string{"requester": "kube:admin"}, LabelValueModeHash)
+	if rendered["owner"] != hashValue("kube:admin") || rendered["bnhp.cloudia/env"] != "build" {
+		t.Error("Unexpected hashed labels:", rendered)
+	}
+	if _, err := ParseLabels("owner"); err == nil {
+		t.Error("Expected error for label without value")
+	}
+	if _, err := ParseLabels("in valid=x"); err == nil {
+		t.Error("Expected error for invalid label key")
+	}
+}
@@ -0,0 +1,75 @@
+package webhook_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+
+	"namespace-admission-controller/webhook"
+	"namespace-admission-controller/webhook/webhooktest"
+)
+
+func TestMatrix(t *testing.T) {
+	labels, err := webhook.ParseLabels(webhooktest.MatrixLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	for _, c := range webhooktest.Matrix() {
+		bhAdmission := &webhook.BhAdmission{
+			ExternalAPIURL:     externalAPI.URL(),
+			ExternalAPITimeout: 5,
+			ExternalAPIMode:    webhook.ExternalAPIModeNotify,
+			Labels:             labels,
+			LabelValueMode:     webhook.LabelValueModeSanitize,
+			Clients:            c.Cluster().Clients(),
+		}
+		review := c.Review.DeepCopy()
+		if err := bhAdmission.HandleAdmission(review); err != nil {
+			t.Fatal(c.Name+":", err)
+		}
+		if problems := c.Check(review.Response, externalAPI.Take()); len(problems) > 0 {
+			t.Errorf("%s: %s", c.Name, strings.Join(problems, ", "))
+		}
+	}
+}
+
+// BenchmarkHandleAdmission runs the creation of every kind of the matrix by a regular user,
+// with fake clients and an external API answering without delay
+func BenchmarkHandleAdmission(b *testing.B) {
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+	logrus.SetOutput(ioutil.Discard)
+	labels, err := webhook.ParseLabels(webhooktest.MatrixLabels)
+	if err != nil {
+		b.Fatal(err)
+	}
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	externalAPI.DiscardCalls()
+	for _, c := range webhooktest.Matrix() {
+		if !strings.HasPrefix(c.Name, "regular user/") || !strings.HasSuffix(c.Name, "/oc create") {
+			continue
+		}
+		bhAdmission := &webhook.BhAdmission{
+			ExternalAPIURL:     externalAPI.URL(),
+			ExternalAPITimeout: 5,
+			ExternalAPIMode:    webhook.ExternalAPIModeNotify,
+			Labels:             labels,
+			LabelValueMode:     webhook.LabelValueModeSanitize,
+			Clients:            c.Cluster().Clients(),
+		}
+		b.Run(strings.Split(c.Name, "/")[1], func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				review := &v1beta1.AdmissionReview{Request: c.Review.Request}
+				if err := bhAdmission.HandleAdmission(review); err != nil || review.Response == nil {
+					b.Fatal(err, review.Response)
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,16 @@ package webhook
 
 import (
 	"encoding/json"
-	"strings"
+	"errors"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"namespace-admission-controller/server"
+	"sort"
+)
+
+const (
+	annotationsPath = "/metadata/annotations"
+	labelsPath      = "/metadata/labels"
 )
 
 type patchOperation struct {
@@ -11,36 +20,174 @@ type patchOperation struct {
 	Value interface{} `json:"value,omitempty"`
 }
 
-func mergeAnnotations(original map[string]string, added map[string]string) map[string]string {
-	mergedMap := map[string]string{}
-	for k, v := range original {
-		if !strings.EqualFold("kubectl.kubernetes.io/last-applied-configuration", k) {
-			mergedMap[k] = v
-		}
+// Mutation is the change to the metadata of an object independent of the patch
+// format. It is rendered as a JSON patch for the webhook or as a JSON merge patch
+// or strategic merge patch for controllers and CLI tools.
+type Mutation struct {
+	Annotations MapMutation
+	Labels      MapMutation
+}
+
+// MapMutation holds the keys set in a metadata map
+type MapMutation struct {
+	// Absent is true when the object has no map yet
+	Absent bool
+	// Added holds the keys missing from the object
+	Added map[string]string
+	// Changed holds the existing keys with a different value
+	Changed map[string]string
+}
+
+// NewMutation computes the changes required to set the added annotations and labels.
+// Keys added by the user or by other webhooks are preserved and keys that already
+// hold the desired value are skipped, so a reinvocation of the webhook results in
+// an empty mutation.
+func NewMutation(requestAnnotations map[string]string, addedAnnotations map[string]string, requestLabels map[string]string, addedLabels map[string]string) *Mutation {
+	return &Mutation{
+		Annotations: newMapMutation(requestAnnotations, addedAnnotations),
+		Labels:      newMapMutation(requestLabels, addedLabels),
 	}
-	for k, v := range added {
-		if !strings.EqualFold("kubectl.kubernetes.io/last-applied-configuration", k) {
-			mergedMap[k] = v
+}
+
+func newMapMutation(requestMap map[string]string, addedMap map[string]string) MapMutation {
+	m := MapMutation{
+		Absent:  requestMap == nil,
+		Added:   map[string]string{},
+		Changed: map[string]string{},
+	}
+	for k, v := range addedMap {
+		if existing, ok := requestMap[k]; !ok {
+			m.Added[k] = v
+		} else if existing != v {
+			m.Changed[k] = v
 		}
 	}
-	return mergedMap
+	return m
+}
+
+// Empty is true when the object already has the desired state
+func (m *MapMutation) Empty() bool {
+	return len(m.Added) == 0 && len(m.Changed) == 0
+}
+
+// values returns all the keys set by the mutation
+func (m *MapMutation) values() map[string]string {
+	values := map[string]string{}
+	for k, v := range m.Added {
+		values[k] = v
+	}
+	for k, v := range m.Changed {
+		values[k] = v
+	}
+	return values
 }
 
-func updateAnnotation(requestAnnotations map[string]string, addedAnnotations map[string]string) (patch []patchOperation) {
-	po := patchOperation{
-		Op:    "add",
-		Path:  "/metadata/annotations",
-		Value: mergeAnnotations(requestAnnotations, addedAnnotations),
+// operations returns the JSON patch operations of the map at path. The map is only
+// created when it is absent.
+func (m *MapMutation) operations(path string) (patch []patchOperation) {
+	if m.Empty() {
+		return patch
+	}
+	if m.Absent {
+		po := patchOperation{
+			Op:    "add",
+			Path:  path,
+			Value: m.values(),
+		}
+		return append(patch, po)
+	}
+	values := m.values()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	// sort the keys to produce the same patch for the same request
+	sort.Strings(keys)
+	for _, k := range keys {
+		op := "add"
+		if _, ok := m.Changed[k]; ok {
+			op = "replace"
+		}
+		patch = append(patch, patchOperation{
+			Op:    op,
+			Path:  path + "/" + server.EscapeJSONPointer(k),
+			Value: values[k],
+		})
 	}
-	patch = append(patch, po)
 	return patch
 }
 
-// create mutation patch for resoures
-func createPatch(requestAnnotations map[string]string, addedAnnotations map[string]string) ([]byte, error) {
+// Empty is true when the object already has the desired state
+func (m *Mutation) Empty() bool {
+	return m.Annotations.Empty() && m.Labels.Empty()
+}
+
+// JSONPatch renders the mutation as a JSON patch (RFC 6902)
+func (m *Mutation) JSONPatch() ([]byte, error) {
+	if m.Empty() {
+		return nil, nil
+	}
 	var patch []patchOperation
+	patch = append(patch, m.Annotations.operations(annotationsPath)...)
+	patch = append(patch, m.Labels.operations(labelsPath)...)
+	return json.Marshal(patch)
+}
 
-	patch = append(patch, updateAnnotation(requestAnnotations, addedAnnotations)...)
+// MergePatch renders the mutation as a JSON merge patch (RFC 7386)
+func (m *Mutation) MergePatch() ([]byte, error) {
+	if m.Empty() {
+		return nil, nil
+	}
+	metadata := map[string]map[string]string{}
+	if !m.Annotations.Empty() {
+		metadata["annotations"] = m.Annotations.values()
+	}
+	if !m.Labels.Empty() {
+		metadata["labels"] = m.Labels.values()
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+	})
+}
 
-	return json.Marshal(patch)
+// StrategicMergePatch renders the mutation as a strategic merge patch. Annotations
+// and labels are plain maps, so the patch is the same as the JSON merge patch. It
+// can not be used for custom resources.
+func (m *Mutation) StrategicMergePatch() ([]byte, error) {
+	return m.MergePatch()
+}
+
+// Patch renders the mutation in the requested format
+func (m *Mutation) Patch(patchType types.PatchType) ([]byte, error) {
+	switch patchType {
+	case types.JSONPatchType:
+		return m.JSONPatch()
+	case types.MergePatchType:
+		return m.MergePatch()
+	case types.StrategicMergePatchType:
+		return m.StrategicMergePatch()
+	}
+	return nil, errors.New("unsupported patch type " + string(patchType))
+}
+
+// create mutation patch for resoures
+func createPatch(requestAnnotations map[string]string, addedAnnotations map[string]string, requestLabels map[string]string, addedLabels map[string]string) ([]byte, error) {
+	return NewMutation(requestAnnotations, addedAnnotations, requestLabels, addedLabels).JSONPatch()
+}
+
+// patchResponse allows the request and adds the patch when there is one
+func patchResponse(patchBytes []byte) *v1beta1.AdmissionResponse {
+	if len(patchBytes) == 0 {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	return &v1beta1.AdmissionResponse{
+		Allowed: true,
+		Patch:   patchBytes,
+		PatchType: func() *v1beta1.PatchType {
+			pt := v1beta1.PatchTypeJSONPatch
+			return &pt
+		}(),
+	}
 }
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"encoding/json"
+	"flag"
+	jsonpatch "github.com/evanphx/json-patch"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreatePatch(t *testing.T) {
+	lastApplied := "kubectl.kubernetes.io/last-applied-configuration"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		expected    []patchOperation
+	}{
+		{
+			name: "absent maps are created",
+			expected: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]interface{}{"company.com/requester": "michael"}},
+				{Op: "add", Path: "/metadata/labels", Value: map[string]interface{}{"owner": "michael"}},
+			},
+		},
+		{
+			name:        "foreign keys are preserved",
+			annotations: map[string]string{lastApplied: "{}", "other": "value"},
+			labels:      map[string]string{},
+			expected: []patchOperation{
+				{Op: "add", Path: "/metadata/annotations/company.com~1requester", Value: "michael"},
+				{Op: "add", Path: "/metadata/labels/owner", Value: "michael"},
+			},
+		},
+		{
+			name:        "changed values are replaced",
+			annotations: map[string]string{"company.com/requester": "fred"},
+			labels:      map[string]string{"owner": "michael"},
+			expected: []patchOperation{
+				{Op: "replace", Path: "/metadata/annotations/company.com~1requester", Value: "michael"},
+			},
+		},
+		{
+			name:        "reinvocation produces no patch",
+			annotations: map[string]string{"company.com/requester": "michael"},
+			labels:      map[string]string{"owner": "michael"},
+		},
+	}
+	for _, test := range tests {
+		patchBytes, err := createPatch(test.annotations, map[string]string{"company.com/requester": "michael"},
+			test.labels, map[string]string{"owner": "michael"})
+		if err != nil {
+			t.Fatal(test.name, err)
+		}
+		if test.expected == nil {
+			if patchBytes != nil {
+				t.Errorf("%s: expected no patch, got %s", test.name, patchBytes)
+			}
+			continue
+		}
+		var patch []patchOperation
+		if err := json.Unmarshal(patchBytes, &patch); err != nil {
+			t.Fatal(test.name, err)
+		}
+		if !reflect.DeepEqual(patch, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, patch)
+		}
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func normalize(t *testing.T, object interface{}) string {
+	b, err := json.MarshalIndent(object, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b) + "\n"
+}
+
+// TestMutationGolden checks that all patch formats produce the same object
+func TestMutationGolden(t *testing.T) {
+	addedAnnotations := map[string]string{"company.com/requester": "kube:admin", "company.com/env": "build"}
+	addedLabels := map[string]string{"owner": "kube_admin", "company.com/env": "build"}
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", "mutation", "*.json"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatal("no test input found", err)
+	}
+	for _, input := range inputs {
+		if strings.HasSuffix(input, ".golden.json") {
+			continue
+		}
+		original, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ns corev1.Namespace
+		if err := json.Unmarshal(original, &ns); err != nil {
+			t.Fatal(input, err)
+		}
+		mutation := NewMutation(ns.Annotations, addedAnnotations, ns.Labels, addedLabels)
+
+		results := map[types.PatchType]string{}
+		for _, patchType := range []types.PatchType{types.JSONPatchType, types.MergePatchType, types.StrategicMergePatchType} {
+			patchBytes, err := mutation.Patch(patchType)
+			if err != nil {
+				t.Fatal(input, patchType, err)
+			}
+			patched := original
+			if patchBytes != nil {
+				switch patchType {
+				case types.JSONPatchType:
+					var patch jsonpatch.Patch
+					if patch, err = jsonpatch.DecodePatch(patchBytes); err == nil {
+						patched, err = patch.Apply(original)
+					}
+				case types.MergePatchType:
+					patched, err = jsonpatch.MergePatch(original, patchBytes)
+				case types.StrategicMergePatchType:
+					patched, err = strategicpatch.StrategicMergePatch(original, patchBytes, corev1.Namespace{})
+				}
+				if err != nil {
+					t.Fatal(input, patchType, err)
+				}
+			}
+			var object map[string]interface{}
+			if err := json.Unmarshal(patched, &object); err != nil {
+				t.Fatal(input, patchType, err)
+			}
+			results[patchType] = normalize(t, object)
+		}
+
+		expected := results[types.JSONPatchType]
+		for patchType, result := range results {
+			if result != expected {
+				t.Errorf("%s: %s result differs from JSON patch:\n%s\n%s", input, patchType, result, expected)
+			}
+		}
+
+		golden := strings.TrimSuffix(input, ".json") + ".golden.json"
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, []byte(expected), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(want) != expected {
+			t.Errorf("%s: result differs from %s:\n%s", input, golden, expected)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package webhook
+
+import (
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"namespace-admission-controller/logging"
+	"namespace-admission-controller/server"
+	"net/http"
+)
+
+const (
+	// OwnerAnnotationPlugin adds the owner annotations and labels
+	OwnerAnnotationPlugin = "owner-annotation"
+	// ExternalRegistrationPlugin registers new objects with the external API
+	ExternalRegistrationPlugin = "external-registration"
+	// NamingPolicyPlugin denies projects and namespaces whose name does not match the naming policy
+	NamingPolicyPlugin = "naming-policy"
+	// QuotaPlugin denies projects and namespaces when their owner has too many of them
+	QuotaPlugin = "quota"
+)
+
+// DefaultPlugins lists the plugins in their default order. Validators run first so that
+// a denied request is not registered with the external API. Validating webhooks run after all
+// mutating webhooks, so a route registering objects runs the validators itself.
+var DefaultPlugins = []string{NamingPolicyPlugin, QuotaPlugin, OwnerAnnotationPlugin, ExternalRegistrationPlugin}
+
+// Plugins returns all plugins in their default order
+func (bhAdmission *BhAdmission) Plugins() []server.AdmissionPlugin {
+	return []server.AdmissionPlugin{
+		&namingPolicy{bhAdmission},
+		&quota{bhAdmission},
+		&ownerAnnotation{bhAdmission},
+		&externalRegistration{bhAdmission},
+	}
+}
+
+var (
+	namespaceRules = []server.PluginRule{
+		{Operations: []v1beta1.Operation{v1beta1.Create}, Version: "v1", Resource: "namespaces", Kind: "Namespace"},
+		{Operations: []v1beta1.Operation{v1beta1.Create}, Group: "project.openshift.io", Version: "v1", Resource: "projects", Kind: "Project"},
+	}
+	accountRules = []server.PluginRule{
+		{Operations: []v1beta1.Operation{v1beta1.Create}, Group: "user.openshift.io", Version: "v1", Resource: "users", Kind: "User"},
+		{Operations: []v1beta1.Operation{v1beta1.Create}, Version: "v1", Resource: "serviceaccounts", Kind: "ServiceAccount"},
+	}
+)
+
+// rules returns the rules of the projects, namespaces, accounts and configured resources
+func (bhAdmission *BhAdmission) rules() []server.PluginRule {
+	return bhAdmission.rulesOf(false)
+}
+
+// rulesOf returns the rules of the projects, namespaces, accounts and the configured resources,
+// only the resources registered with the external API when registered is true
+func (bhAdmission *BhAdmission) rulesOf(registered bool) []server.PluginRule {
+	rules := append([]server.PluginRule{}, namespaceRules...)
+	rules = append(rules, accountRules...)
+	for _, rule := range bhAdmission.ResourceRules {
+		if registered && !rule.RegisterExternal {
+			continue
+		}
+		version := rule.Version
+		if version == "*" {
+			version = ""
+		}
+		rules = append(rules, server.PluginRule{
+			Operations: rule.Operations,
+			Group:      rule.Group,
+			Version:    version,
+			Resource:   rule.Resource,
+		})
+	}
+	return rules
+}
+
+func denied(message string) *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: message,
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+		},
+	}
+}
+
+type ownerAnnotation struct {
+	bhAdmission *BhAdmission
+}
+
+func (p *ownerAnnotation) Name() string {
+	return OwnerAnnotationPlugin
+}
+
+func (p *ownerAnnotation) Type() server.PluginType {
+	return server.Mutating
+}
+
+func (p *ownerAnnotation) Rules() []server.PluginRule {
+	return p.bhAdmission.rules()
+}
+
+func (p *ownerAnnotation) Finish(request *v1beta1.AdmissionRequest) {
+	p.bhAdmission.subjects.forget(request)
+}
+
+func (p *ownerAnnotation) Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	subject, err := p.bhAdmission.subject(request)
+	if err != nil {
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "Failed to unmarshal " + request.Kind.Kind + ": " + err.Error(),
+			},
+		}
+	}
+	if subject == nil {
+		return nil
+	}
+
+	patchBytes, err := createPatch(subject.annotations, subject.newAnnotations, subject.labels, subject.newLabels)
+	if err != nil {
+		p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeWarning, EventPatchFailed,
+			"Failed to add the owner annotations and labels: "+err.Error())
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "createPatch failed: " + err.Error(),
+			},
+		}
+	}
+
+	logging.ForRequest(request).Debugln("AdmissionResponse:", string(patchBytes))
+	return patchResponse(patchBytes)
+}
+
+type externalRegistration struct {
+	bhAdmission *BhAdmission
+}
+
+func (p *externalRegistration) Name() string {
+	return ExternalRegistrationPlugin
+}
+
+func (p *externalRegistration) Type() server.PluginType {
+	return server.Validating
+}
+
+func (p *externalRegistration) Rules() []server.PluginRule {
+	return p.bhAdmission.rulesOf(true)
+}
+
+func (p *externalRegistration) HasSideEffects() bool {
+	return true
+}
+
+func (p *externalRegistration) Finish(request *v1beta1.AdmissionRequest) {
+	p.bhAdmission.subjects.forget(request)
+}
+
+func (p *externalRegistration) Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	subject, err := p.bhAdmission.subject(request)
+	if err != nil || subject == nil || !subject.register {
+		return nil
+	}
+	log := logging.ForRequest(request)
+	if request.DryRun != nil && *request.DryRun {
+		// a dry-run must not create the registration
+		if p.bhAdmission.ExternalAPIMode == ExternalAPIModeGate {
+			return denied("dry-run requests can not be approved by the external API gate")
+		}
+		log.Debugln("Skipping the external API for a dry-run request")
+		return nil
+	}
+	decision, err := p.bhAdmission.prepareAndInvokeExternal(log, subject.identifierType, subject.identifier, subject.newLabels)
+	if denied := p.bhAdmission.gateResponse(log, decision, err); denied != nil {
+		p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeWarning, EventDenied, denied.Result.Message)
+		return denied
+	}
+	registration := subject.identifierType + " " + subject.identifier
+	if err != nil {
+		p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeWarning, EventRegistrationFailed,
+			"Registration of "+registration+" with the external API failed: "+err.Error())
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: "invokeExternal failed: " + err.Error(),
+			},
+		}
+	}
+	if len(p.bhAdmission.ExternalAPIURL) > 0 {
+		p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeNormal, EventRegistered,
+			"Registered "+registration+" with the external API")
+	}
+	return nil
+}
+
+type namingPolicy struct {
+	bhAdmission *BhAdmission
+}
+
+func (p *namingPolicy) Name() string {
+	return NamingPolicyPlugin
+}
+
+func (p *namingPolicy) Type() server.PluginType {
+	return server.Validating
+}
+
+func (p *namingPolicy) Rules() []server.PluginRule {
+	return namespaceRules
+}
+
+func (p *namingPolicy) Finish(request *v1beta1.AdmissionRequest) {
+	p.bhAdmission.subjects.forget(request)
+}
+
+func (p *namingPolicy) Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if p.bhAdmission.NamingPolicy == nil {
+		return nil
+	}
+	subject, err := p.bhAdmission.subject(request)
+	if err != nil || subject == nil {
+		return nil
+	}
+	if !p.bhAdmission.NamingPolicy.MatchString(subject.name) {
+		message := "name " + subject.name + " does not match the naming policy " + p.bhAdmission.NamingPolicy.String()
+		p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeWarning, EventDenied, message)
+		return denied(message)
+	}
+	return nil
+}
+
+type quota struct {
+	bhAdmission *BhAdmission
+}
+
+func (p *quota) Name() string {
+	return QuotaPlugin
+}
+
+func (p *quota) Type() server.PluginType {
+	return server.Validating
+}
+
+func (p *quota) Rules() []server.PluginRule {
+	return namespaceRules
+}
+
+func (p *quota) Finish(request *v1beta1.AdmissionRequest) {
+	p.bhAdmission.subjects.forget(request)
+}
+
+func (p *quota) Admit(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if p.bhAdmission.MaxNamespacesPerOwner <= 0 {
+		return nil
+	}
+	subject, err := p.bhAdmission.subject(request)
+	if err != nil || subject == nil {
+		return nil
+	}
+	// the owner label is set by the webhook when the project is created. Users allowed to update namespaces
+	// can still change or remove it, the quota only counts the projects that keep it.
+	ownerLabel := OwnerLabel(p.bhAdmission.Labels)
+	if len(ownerLabel) == 0 {
+		return nil
+	}
+	selector := labels.SelectorFromSet(labels.Set{ownerLabel: labelValue(subject.requester, p.bhAdmission.LabelValueMode)})
+	namespaces, err := p.bhAdmission.Clients.Core.Namespaces().List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		quotaError.Inc()
+		logging.ForRequest(request).Errorln("Failed to list namespaces for quota:", err)
+		if p.bhAdmission.QuotaFailurePolicy == FailurePolicyFail {
+			message := "the projects of " + subject.requester + " could not be counted"
+			p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeWarning, EventDenied, message)
+			return denied(message)
+		}
+		return nil
+	}
+	owned := len(namespaces.Items)
+	if owned >= p.bhAdmission.MaxNamespacesPerOwner {
+		message := subject.requester + " already owns the maximum number of projects"
+		p.bhAdmission.recordEvent(request, subject.name, corev1.EventTypeWarning, EventDenied, message)
+		return denied(message)
+	}
+	return nil
+}
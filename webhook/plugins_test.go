@@ -0,0 +1,103 @@
+package webhook_test
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"namespace-admission-controller/server"
+	"namespace-admission-controller/webhook"
+	"namespace-admission-controller/webhook/webhooktest"
+)
+
+// TestSubjectResolvedOnce runs all plugins on a new project, the project is looked up once for the chain
+func TestSubjectResolvedOnce(t *testing.T) {
+	externalAPI := webhooktest.NewExternalAPI()
+	defer externalAPI.Close()
+	c := matrixCase(t, "regular user/project/oc create")
+	cluster := c.Cluster()
+	bhAdmission := &webhook.BhAdmission{
+		ExternalAPIURL:        externalAPI.URL(),
+		ExternalAPITimeout:    5,
+		ExternalAPIMode:       webhook.ExternalAPIModeGate,
+		LabelValueMode:        webhook.LabelValueModeSanitize,
+		NamingPolicy:          regexp.MustCompile(".*"),
+		MaxNamespacesPerOwner: 10,
+		Clients:               cluster.Clients(),
+	}
+	for i := 0; i < 2; i++ {
+		review := &v1beta1.AdmissionReview{Request: c.Review.Request.DeepCopy()}
+		if err := bhAdmission.HandleAdmission(review); err != nil {
+			t.Fatal(err)
+		}
+		if review.Response == nil || !review.Response.Allowed || len(review.Response.Patch) == 0 {
+			t.Fatalf("expected an allowed and patched response, got %+v", review.Response)
+		}
+	}
+	if len(externalAPI.Take()) != 2 {
+		t.Error("expected a registration per request")
+	}
+	gets := 0
+	for _, action := range cluster.Kube.Actions() {
+		if action.GetVerb() == "get" && action.GetResource().Resource == "namespaces" {
+			gets++
+		}
+	}
+	// the second request has the same UID, it is resolved again
+	if gets != 2 {
+		t.Errorf("expected one lookup of the project per request, got %d", gets)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	owned := func(name string, labels map[string]string, annotations map[string]string) runtime.Object {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+	}
+	tests := []struct {
+		name          string
+		max           int
+		failurePolicy string
+		listError     bool
+		allowed       bool
+	}{
+		{name: "below the quota", max: 2, allowed: true},
+		{name: "at the quota", max: 1, allowed: false},
+		{name: "list error ignored", max: 1, failurePolicy: webhook.FailurePolicyIgnore, listError: true, allowed: true},
+		{name: "list error fails", max: 1, failurePolicy: webhook.FailurePolicyFail, listError: true, allowed: false},
+	}
+	c := matrixCase(t, "regular user/project/oc create")
+	requester := c.Review.Request.UserInfo.Username
+	for _, test := range tests {
+		// the annotation can not be selected, only the label is counted
+		cluster := webhooktest.NewCluster(
+			owned("labelled", map[string]string{"bnhp.cloudia/owner": requester}, nil),
+			owned("annotated", nil, map[string]string{"bnhp.cloudia/owner": requester}),
+			owned("edited", nil, map[string]string{"bnhp.cloudia/owner": requester}),
+		)
+		if test.listError {
+			cluster.Kube.PrependReactor("list", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("unavailable")
+			})
+		}
+		bhAdmission := &webhook.BhAdmission{
+			QuotaFailurePolicy:    test.failurePolicy,
+			Labels:                map[string]string{"bnhp.cloudia/owner": "{requester}"},
+			LabelValueMode:        webhook.LabelValueModeSanitize,
+			MaxNamespacesPerOwner: test.max,
+			Clients:               cluster.Clients(),
+		}
+		chain := server.NewPluginChain(bhAdmission.Plugins()[1])
+		review := &v1beta1.AdmissionReview{Request: c.Review.Request.DeepCopy()}
+		if err := chain.HandleAdmission(review); err != nil {
+			t.Fatal(test.name+":", err)
+		}
+		if review.Response == nil || review.Response.Allowed != test.allowed {
+			t.Errorf("%s: expected allowed %v, got %+v", test.name, test.allowed, review.Response)
+		}
+	}
+}
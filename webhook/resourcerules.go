@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"errors"
+	"io/ioutil"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// ResourceRule defines the annotations and labels added to one GroupVersionResource
+type ResourceRule struct {
+	// Group of the resource, empty for the core group
+	Group string `json:"group"`
+	// Version of the resource, empty or "*" for all versions
+	Version string `json:"version,omitempty"`
+	// Resource is the plural resource name, for example "secrets"
+	Resource string `json:"resource"`
+	// Operations handled by the rule, CREATE when empty
+	Operations []v1beta1.Operation `json:"operations,omitempty"`
+	// Annotations added to the resource, the owner annotations when empty.
+	// The values may contain the {requester}, {cluster}, {name} and {namespace} placeholders.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Labels added to the resource, with the same placeholders as the annotations
+	Labels map[string]string `json:"labels,omitempty"`
+	// RegisterExternal invokes the external API when the resource is created
+	RegisterExternal bool `json:"registerExternal,omitempty"`
+	// IdentifierType sent to the external API, the resource name when empty
+	IdentifierType string `json:"identifierType,omitempty"`
+}
+
+type resourceRules struct {
+	Rules []ResourceRule `json:"rules"`
+}
+
+// LoadResourceRules reads the resource rules from a YAML file. A missing file results in no rules.
+func LoadResourceRules(file string) ([]ResourceRule, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseResourceRules(data)
+}
+
+// ParseResourceRules parses and validates YAML resource rules
+func ParseResourceRules(data []byte) ([]ResourceRule, error) {
+	var rules resourceRules
+	if err := yaml.UnmarshalStrict(data, &rules); err != nil {
+		return nil, err
+	}
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if len(rule.Resource) == 0 {
+			return nil, errors.New("resource rule without resource")
+		}
+		if len(rule.Operations) == 0 {
+			rule.Operations = []v1beta1.Operation{v1beta1.Create}
+		}
+		for _, op := range rule.Operations {
+			if op != v1beta1.Create && op != v1beta1.Update {
+				return nil, errors.New("resource rule for " + rule.Resource + ": unsupported operation " + string(op))
+			}
+		}
+		for key := range rule.Labels {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return nil, errors.New("resource rule for " + rule.Resource + ": invalid label key " + key + ": " + strings.Join(errs, "; "))
+			}
+		}
+		for key := range rule.Annotations {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return nil, errors.New("resource rule for " + rule.Resource + ": invalid annotation key " + key + ": " + strings.Join(errs, "; "))
+			}
+		}
+	}
+	return rules.Rules, nil
+}
+
+// matches is true when the rule applies to the request. Requests for subresources, for example
+// the status of a resource, are not handled.
+func (rule *ResourceRule) matches(request *v1beta1.AdmissionRequest) bool {
+	if len(request.SubResource) > 0 {
+		return false
+	}
+	resource := request.Resource
+	if resource.Group != rule.Group || !strings.EqualFold(resource.Resource, rule.Resource) {
+		return false
+	}
+	if len(rule.Version) > 0 && rule.Version != "*" && rule.Version != resource.Version {
+		return false
+	}
+	for _, op := range rule.Operations {
+		if op == request.Operation {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceRule returns the first rule matching the request
+func (bhAdmission *BhAdmission) resourceRule(request *v1beta1.AdmissionRequest) *ResourceRule {
+	for i := range bhAdmission.ResourceRules {
+		if bhAdmission.ResourceRules[i].matches(request) {
+			return &bhAdmission.ResourceRules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package webhooktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	userv1 "github.com/openshift/api/user/v1"
+	"k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// MatrixLabels is the labels setting of the webhook expected by the cases of Matrix
+const MatrixLabels = "bnhp.cloudia/name={name}"
+
+// Case is a row of testing/test_matrix.txt, an AdmissionReview and the outcome expected from the
+// owner-annotation and external-registration plugins in notify mode with the labels of MatrixLabels
+type Case struct {
+	Name   string
+	Review *v1beta1.AdmissionReview
+	// Existing is the object in the cluster before the request, nil for a new object
+	Existing runtime.Object
+	// Annotations and Labels are expected on the patched object, nil when no patch is expected
+	Annotations map[string]string
+	Labels      map[string]string
+	// ExternalType and ExternalIdentifier are sent to the external API, it is not called when they are empty
+	ExternalType       string
+	ExternalIdentifier string
+}
+
+// matrixKind is an object kind of the matrix
+type matrixKind struct {
+	name      string
+	kind      metav1.GroupVersionKind
+	resource  string
+	namespace string
+	existing  runtime.Object
+	// account is true for users and service accounts, they are registered with the external API
+	// in notify mode and do not take the requester from the openshift.io/requester annotation
+	account            bool
+	externalType       string
+	externalIdentifier string
+}
+
+var matrixKinds = []matrixKind{
+	{
+		name:               "user",
+		kind:               metav1.GroupVersionKind{Group: "user.openshift.io", Version: "v1", Kind: "User"},
+		resource:           "users",
+		existing:           &userv1.User{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+		account:            true,
+		externalType:       "user",
+		externalIdentifier: "test",
+	},
+	{
+		name:               "serviceaccount",
+		kind:               metav1.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"},
+		resource:           "serviceaccounts",
+		namespace:          "team-a",
+		existing:           &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "team-a"}},
+		account:            true,
+		externalType:       "sa",
+		externalIdentifier: "team-a-test",
+	},
+	{
+		name:     "namespace",
+		kind:     metav1.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+		resource: "namespaces",
+		existing: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+	},
+	{
+		// a project is a namespace, an existing project is found as a namespace
+		name:     "project",
+		kind:     metav1.GroupVersionKind{Group: "project.openshift.io", Version: "v1", Kind: "Project"},
+		resource: "projects",
+		existing: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+	},
+}
+
+// matrixCreate is a way of creating an object of the matrix
+type matrixCreate struct {
+	name     string
+	metadata map[string]interface{}
+	existing bool
+}
+
+var matrixCreates = []matrixCreate{
+	// oc create sends the name and a null creationTimestamp
+	{name: "oc create", metadata: map[string]interface{}{"name": "test", "creationTimestamp": nil}},
+	{name: "oc create -f", metadata: map[string]interface{}{"name": "test", "labels": map[string]interface{}{"app": "test"}}},
+	{name: "oc create -f with annotations", metadata: map[string]interface{}{
+		"name":        "test",
+		"annotations": map[string]interface{}{"myannotation": "junk", "openshift.io/requester": "fred"},
+	}},
+	{name: "oc create for existing", metadata: map[string]interface{}{"name": "test", "creationTimestamp": nil}, existing: true},
+	{name: "oc create -f for existing", metadata: map[string]interface{}{"name": "test", "labels": map[string]interface{}{"app": "test"}}, existing: true},
+}
+
+var matrixRequesters = []struct {
+	name     string
+	username string
+}{
+	{"regular user", "alice"},
+	{"serviceaccount", "system:serviceaccount:team-a:deployer"},
+}
+
+// Matrix returns the rows of testing/test_matrix.txt, run by a regular user and by a service account
+func Matrix() []Case {
+	cases := []Case{}
+	for _, requester := range matrixRequesters {
+		for _, kind := range matrixKinds {
+			for _, create := range matrixCreates {
+				cases = append(cases, newCase(requester.name+"/"+kind.name+"/"+create.name, requester.username, kind, create))
+			}
+		}
+	}
+	return cases
+}
+
+func newCase(name, username string, kind matrixKind, create matrixCreate) Case {
+	object, err := json.Marshal(map[string]interface{}{
+		"apiVersion": schema.GroupVersion{Group: kind.kind.Group, Version: kind.kind.Version}.String(),
+		"kind":       kind.kind.Kind,
+		"metadata":   create.metadata,
+	})
+	if err != nil {
+		panic(err)
+	}
+	c := Case{
+		Name: name,
+		Review: &v1beta1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{Kind: "AdmissionReview", APIVersion: v1beta1.SchemeGroupVersion.String()},
+			Request: &v1beta1.AdmissionRequest{
+				UID:       "e911857d-c318-11e8-bbad-025000000001",
+				Kind:      kind.kind,
+				Resource:  metav1.GroupVersionResource{Group: kind.kind.Group, Version: kind.kind.Version, Resource: kind.resource},
+				Name:      "test",
+				Namespace: kind.namespace,
+				Operation: v1beta1.Create,
+				UserInfo:  authenticationv1.UserInfo{Username: username},
+				Object:    runtime.RawExtension{Raw: object},
+			},
+		},
+	}
+	if create.existing {
+		c.Existing = kind.existing
+		return c
+	}
+	requester := username
+	c.Annotations = map[string]string{}
+	c.Labels = map[string]string{}
+	if annotations, ok := create.metadata["annotations"].(map[string]interface{}); ok {
+		for key, value := range annotations {
+			c.Annotations[key] = value.(string)
+		}
+		// oc new-project sets the requester of projects and namespaces
+		if value, ok := annotations["openshift.io/requester"]; ok && !kind.account {
+			requester = value.(string)
+		}
+	}
+	if labels, ok := create.metadata["labels"].(map[string]interface{}); ok {
+		for key, value := range labels {
+			c.Labels[key] = value.(string)
+		}
+	}
+	c.Annotations["bnhp.com/requester"] = requester
+	c.Annotations["bnhp.cloudia/owner"] = requester
+	c.Annotations["bnhp.cloudia/env"] = "build"
+	c.Labels["bnhp.cloudia/name"] = "test"
+	if kind.account {
+		c.ExternalType = kind.externalType
+		c.ExternalIdentifier = kind.externalIdentifier
+	}
+	return c
+}
+
+// Cluster returns a cluster with the existing object of the case
+func (c *Case) Cluster() *Cluster {
+	if c.Existing == nil {
+		return NewCluster()
+	}
+	return NewCluster(c.Existing.DeepCopyObject())
+}
+
+// matrixObject is the object of a request after the patch
+type matrixObject struct {
+	Metadata metav1.ObjectMeta `json:"metadata"`
+}
+
+// Check compares the response and the bodies sent to the external API with the expected outcome
+// and returns the differences
+func (c *Case) Check(response *v1beta1.AdmissionResponse, calls [][]byte) []string {
+	problems := []string{}
+	if response == nil || !response.Allowed {
+		return append(problems, fmt.Sprintf("request was not allowed: %+v", response))
+	}
+	if c.Annotations == nil {
+		if len(response.Patch) > 0 {
+			problems = append(problems, "unexpected patch "+string(response.Patch))
+		}
+	} else {
+		patch, err := jsonpatch.DecodePatch(response.Patch)
+		if err != nil {
+			return append(problems, fmt.Sprintf("invalid patch %s: %v", response.Patch, err))
+		}
+		patched, err := patch.Apply(c.Review.Request.Object.Raw)
+		if err != nil {
+			return append(problems, fmt.Sprintf("patch %s does not apply: %v", response.Patch, err))
+		}
+		var object matrixObject
+		if err := json.Unmarshal(patched, &object); err != nil {
+			return append(problems, err.Error())
+		}
+		if !reflect.DeepEqual(object.Metadata.Annotations, c.Annotations) {
+			problems = append(problems, fmt.Sprintf("expected annotations %v, got %v", c.Annotations, object.Metadata.Annotations))
+		}
+		if !reflect.DeepEqual(object.Metadata.Labels, c.Labels) {
+			problems = append(problems, fmt.Sprintf("expected labels %v, got %v", c.Labels, object.Metadata.Labels))
+		}
+	}
+
+	if len(c.ExternalType) == 0 {
+		if len(calls) > 0 {
+			problems = append(problems, fmt.Sprintf("unexpected external API calls %q", calls))
+		}
+		return problems
+	}
+	if len(calls) != 1 {
+		return append(problems, fmt.Sprintf("expected a single external API call, got %q", calls))
+	}
+	var call struct {
+		Type       string            `json:"type"`
+		Identifier string            `json:"identifier"`
+		Labels     map[string]string `json:"labels"`
+	}
+	if err := json.Unmarshal(calls[0], &call); err != nil {
+		return append(problems, "invalid external API call: "+err.Error())
+	}
+	// the labels added to the object are sent to the external API
+	if call.Type != c.ExternalType || call.Identifier != c.ExternalIdentifier ||
+		!reflect.DeepEqual(call.Labels, map[string]string{"bnhp.cloudia/name": "test"}) {
+		problems = append(problems, "unexpected external API call "+string(calls[0]))
+	}
+	return problems
+}
@@ -0,0 +1,179 @@
+// Package webhooktest provides fake API clients, a stub external API and an event recorder for
+// running the plugins without a cluster, in tests, in the replay command and in load tests.
+package webhooktest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	userv1 "github.com/openshift/api/user/v1"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"namespace-admission-controller/webhook"
+)
+
+// Cluster holds the objects seen by the plugins in fake clientsets
+type Cluster struct {
+	Kube  *fake.Clientset
+	Users *userfake.Clientset
+}
+
+// NewCluster returns a cluster with the objects. Users are kept by the OpenShift user
+// clientset, all other objects by the Kubernetes clientset.
+func NewCluster(objects ...runtime.Object) *Cluster {
+	kubeObjects := []runtime.Object{}
+	userObjects := []runtime.Object{}
+	for _, object := range objects {
+		if _, ok := object.(*userv1.User); ok {
+			userObjects = append(userObjects, object)
+		} else {
+			kubeObjects = append(kubeObjects, object)
+		}
+	}
+	return &Cluster{
+		Kube:  fake.NewSimpleClientset(kubeObjects...),
+		Users: userfake.NewSimpleClientset(userObjects...),
+	}
+}
+
+// Clients returns the clients of the plugins
+func (cluster *Cluster) Clients() webhook.Clients {
+	return webhook.Clients{Core: cluster.Kube.CoreV1(), User: cluster.Users.UserV1()}
+}
+
+// ExternalAPI answers every call with the same response after a delay and records the request bodies
+type ExternalAPI struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	status   int
+	response []byte
+	delay    time.Duration
+	calls    [][]byte
+	// discard counts the calls without keeping their bodies
+	discard bool
+	count   int
+}
+
+// NewExternalAPI starts an external API allowing every request
+func NewExternalAPI() *ExternalAPI {
+	api := &ExternalAPI{status: http.StatusOK, response: []byte(`{"allowed": true}`)}
+	api.server = httptest.NewServer(api)
+	return api
+}
+
+// URL is the external_api_url of the stub
+func (api *ExternalAPI) URL() string {
+	return api.server.URL
+}
+
+// Close stops the stub
+func (api *ExternalAPI) Close() {
+	api.server.Close()
+}
+
+// Respond replaces the status and the body of the responses
+func (api *ExternalAPI) Respond(status int, response string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.status = status
+	api.response = []byte(response)
+}
+
+// SetDelay delays every response, for simulating a slow external API
+func (api *ExternalAPI) SetDelay(delay time.Duration) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.delay = delay
+}
+
+// DiscardCalls stops recording the request bodies, for long runs that only need Count
+func (api *ExternalAPI) DiscardCalls() {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.discard = true
+	api.calls = nil
+}
+
+// Count returns the number of calls received since the stub started
+func (api *ExternalAPI) Count() int {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return api.count
+}
+
+func (api *ExternalAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	api.mutex.Lock()
+	api.count++
+	if !api.discard {
+		api.calls = append(api.calls, body)
+	}
+	status, response, delay := api.status, api.response, api.delay
+	api.mutex.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(response)
+}
+
+// Take returns the request bodies received since the previous Take
+func (api *ExternalAPI) Take() [][]byte {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	calls := api.calls
+	api.calls = nil
+	return calls
+}
+
+// Event is an event recorded by Recorder
+type Event struct {
+	Object  runtime.Object
+	Type    string
+	Reason  string
+	Message string
+}
+
+// Recorder keeps the events of the plugins in memory
+type Recorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+// Event records an event
+func (recorder *Recorder) Event(object runtime.Object, eventtype, reason, message string) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.events = append(recorder.events, Event{Object: object, Type: eventtype, Reason: reason, Message: message})
+}
+
+// Eventf records an event with a formatted message
+func (recorder *Recorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	recorder.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// PastEventf records an event, the timestamp is ignored
+func (recorder *Recorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf records an event, the annotations are ignored
+func (recorder *Recorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// Take returns the events recorded since the previous Take
+func (recorder *Recorder) Take() []Event {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	events := recorder.events
+	recorder.events = nil
+	return events
+}